@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"github.com/arkreddy21/eligos"
+	"github.com/arkreddy21/eligos/internal/export"
+	"github.com/arkreddy21/eligos/internal/postgres"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runCommand runs an administrative subcommand instead of the server
+func runCommand(name string, args []string) {
+	switch name {
+	case "unlock":
+		runUnlock(args)
+	case "genkey":
+		runGenkey(args)
+	case "export":
+		runExport(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\ncommands:\n  unlock  clear failed login attempts of an account or ip address\n  genkey  create a new access token signing key\n  export  write a zip archive of all data stored about a user\n", name)
+		os.Exit(2)
+	}
+}
+
+// runUnlock clears the failed attempts and lockouts of an account or ip address
+func runUnlock(args []string) {
+	fs := flag.NewFlagSet("unlock", flag.ExitOnError)
+	email := fs.String("email", "", "email of the account to unlock")
+	ip := fs.String("ip", "", "ip address to unlock")
+	fs.Parse(args)
+	if *email == "" && *ip == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	db := postgres.NewDB()
+	defer db.Close()
+	attempts := postgres.NewLoginAttemptService(db)
+	var keys []string
+	if *email != "" {
+		keys = append(keys, eligos.AccountAttemptKey(*email))
+	}
+	if *ip != "" {
+		keys = append(keys, eligos.IpAttemptKey(eligos.AttemptLogin, *ip), eligos.IpAttemptKey(eligos.AttemptRegister, *ip))
+	}
+	for _, key := range keys {
+		if err := attempts.ResetAttempts(key); err != nil {
+			log.Fatal("unable to unlock: ", err)
+		}
+	}
+	fmt.Println("unlocked")
+}
+
+// runGenkey writes a new private key to the signing key directory. The server signs
+// with it after a restart, while the previous keys are accepted for a grace period
+// counted from the creation time the key is named after.
+func runGenkey(args []string) {
+	fs := flag.NewFlagSet("genkey", flag.ExitOnError)
+	dir := fs.String("dir", os.Getenv("ELIGOSJWTKEYDIR"), "signing key directory, defaults to ELIGOSJWTKEYDIR")
+	alg := fs.String("alg", "EdDSA", "key algorithm, EdDSA or RS256")
+	fs.Parse(args)
+	if *dir == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var private any
+	var err error
+	switch *alg {
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		log.Fatalf("unsupported algorithm %q", *alg)
+	}
+	if err != nil {
+		log.Fatal("unable to generate key: ", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		log.Fatal("unable to encode key: ", err)
+	}
+	err = os.MkdirAll(*dir, 0o700)
+	if err != nil {
+		log.Fatal("unable to create key directory: ", err)
+	}
+	kid := time.Now().UTC().Format("20060102T150405Z")
+	file := filepath.Join(*dir, kid+".pem")
+	err = os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	if err != nil {
+		log.Fatal("unable to write key: ", err)
+	}
+	fmt.Println("wrote", file)
+}
+
+// runExport writes the personal data of a user to a zip file, for data access
+// requests that arrive outside the app
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	email := fs.String("email", "", "email of the account to export")
+	out := fs.String("out", "", "file to write, defaults to eligos-export-<email>.zip")
+	fs.Parse(args)
+	if *email == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *out == "" {
+		*out = "eligos-export-" + *email + ".zip"
+	}
+
+	db := postgres.NewDB()
+	defer db.Close()
+	exporter := &export.Exporter{
+		UserService:    postgres.NewUserService(db),
+		MessageService: postgres.NewMessageService(db),
+		InviteService:  postgres.NewInviteService(db),
+	}
+	user, err := exporter.UserService.GetUser(*email)
+	if err != nil {
+		log.Fatal("user not found: ", err)
+	}
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		log.Fatal("unable to create export file: ", err)
+	}
+	err = exporter.WriteZip(f, user.Id)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(*out)
+		log.Fatal("unable to export: ", err)
+	}
+	fmt.Println("wrote", *out)
+}
@@ -3,14 +3,24 @@ package main
 import (
 	"context"
 	"fmt"
+	"github.com/arkreddy21/eligos"
 	"github.com/arkreddy21/eligos/internal/http"
+	"github.com/arkreddy21/eligos/internal/mail"
+	"github.com/arkreddy21/eligos/internal/oidc"
+	"github.com/arkreddy21/eligos/internal/password"
 	"github.com/arkreddy21/eligos/internal/postgres"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
@@ -44,11 +54,109 @@ func (app *App) run() {
 	app.DB = postgres.NewDB()
 	app.HTTPServer.UserService = postgres.NewUserService(app.DB)
 	app.HTTPServer.SpaceService = postgres.NewSpaceService(app.DB)
+	app.HTTPServer.ChannelService = postgres.NewChannelService(app.DB)
 	app.HTTPServer.MessageService = postgres.NewMessageService(app.DB)
 	app.HTTPServer.InviteService = postgres.NewInviteService(app.DB)
+	app.HTTPServer.InviteLinkService = postgres.NewInviteLinkService(app.DB)
+	app.HTTPServer.BanService = postgres.NewBanService(app.DB)
+	app.HTTPServer.AuditService = postgres.NewAuditService(app.DB)
+	app.HTTPServer.SessionService = postgres.NewSessionService(app.DB)
+	app.HTTPServer.UserTokenService = postgres.NewUserTokenService(app.DB)
+	app.HTTPServer.OidcService = postgres.NewOidcService(app.DB)
+	app.HTTPServer.ApiTokenService = postgres.NewApiTokenService(app.DB)
+	app.HTTPServer.LoginAttemptService = postgres.NewLoginAttemptService(app.DB)
+	app.HTTPServer.Mailer = newMailer()
+	app.HTTPServer.PasswordHasher = newPasswordHasher()
+	app.HTTPServer.PasswordPolicy = newPasswordPolicy()
+	app.HTTPServer.OidcProvider = newOidcProvider()
 	app.HTTPServer.Open()
 }
 
+// newMailer sends mail through the SMTP server in ELIGOSSMTPADDR if set,
+// otherwise emails are written to the ELIGOSMAILOUTBOX directory
+func newMailer() eligos.Mailer {
+	from, ok := os.LookupEnv("ELIGOSMAILFROM")
+	if !ok {
+		from = "eligos <noreply@localhost>"
+	}
+	if addr, ok := os.LookupEnv("ELIGOSSMTPADDR"); ok {
+		return mail.NewSMTPMailer(addr, os.Getenv("ELIGOSSMTPUSER"), os.Getenv("ELIGOSSMTPPASSWORD"), from)
+	}
+	dir, ok := os.LookupEnv("ELIGOSMAILOUTBOX")
+	if !ok {
+		dir = "outbox"
+	}
+	mailer, err := mail.NewOutboxMailer(dir, from)
+	if err != nil {
+		log.Fatal("unable to create mail outbox: ", err)
+	}
+	return mailer
+}
+
+// newPasswordHasher hashes new passwords with the algorithm in ELIGOSPASSWORDHASH,
+// argon2id by default. Existing passwords are rehashed when their users login.
+func newPasswordHasher() password.Hasher {
+	switch algorithm := os.Getenv("ELIGOSPASSWORDHASH"); algorithm {
+	case "", "argon2id":
+		hasher := password.DefaultArgon2id
+		hasher.Memory = uint32(envInt("ELIGOSARGON2MEMORY", int(hasher.Memory)))
+		hasher.Time = uint32(envInt("ELIGOSARGON2TIME", int(hasher.Time)))
+		hasher.Threads = uint8(envInt("ELIGOSARGON2THREADS", int(hasher.Threads)))
+		return hasher
+	case "bcrypt":
+		return password.Bcrypt{Cost: envInt("ELIGOSBCRYPTCOST", 12)}
+	default:
+		log.Fatalf("unknown ELIGOSPASSWORDHASH %q, use argon2id or bcrypt", algorithm)
+		return nil
+	}
+}
+
+// newPasswordPolicy reads password rules from the environment. ELIGOSBANNEDPASSWORDS
+// is a file of passwords that can't be used, one per line.
+func newPasswordPolicy() *password.Policy {
+	policy := password.DefaultPolicy
+	policy.MinLength = envInt("ELIGOSPASSWORDMINLENGTH", policy.MinLength)
+	policy.MaxLength = envInt("ELIGOSPASSWORDMAXLENGTH", policy.MaxLength)
+	if file, ok := os.LookupEnv("ELIGOSBANNEDPASSWORDS"); ok {
+		err := policy.LoadBannedPasswords(file)
+		if err != nil {
+			log.Fatal("unable to load banned passwords: ", err)
+		}
+	}
+	return &policy
+}
+
+// envInt reads an integer env variable, returning def if it isn't set
+func envInt(name string, def int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer", name)
+	}
+	return i
+}
+
+// newOidcProvider configures single sign-on if ELIGOSOIDCISSUER is set
+func newOidcProvider() *oidc.Provider {
+	issuer, ok := os.LookupEnv("ELIGOSOIDCISSUER")
+	if !ok {
+		return nil
+	}
+	config := oidc.Config{
+		Issuer:       issuer,
+		ClientId:     os.Getenv("ELIGOSOIDCCLIENTID"),
+		ClientSecret: os.Getenv("ELIGOSOIDCCLIENTSECRET"),
+		RedirectUrl:  os.Getenv("ELIGOSOIDCREDIRECTURL"),
+	}
+	if config.ClientId == "" || config.RedirectUrl == "" {
+		log.Fatal("ELIGOSOIDCCLIENTID and ELIGOSOIDCREDIRECTURL env variables must be set to use single sign-on")
+	}
+	return oidc.NewProvider(config)
+}
+
 func (app *App) close() error {
 	err := app.HTTPServer.Close()
 	if err != nil {
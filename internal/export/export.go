@@ -0,0 +1,162 @@
+// Package export builds an archive of all the data eligos holds about a user,
+// to answer data access requests.
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"github.com/arkreddy21/eligos"
+	"github.com/google/uuid"
+	"html/template"
+	"io"
+	"time"
+)
+
+type Exporter struct {
+	UserService    eligos.UserServiceI
+	MessageService eligos.MessageServiceI
+	InviteService  eligos.InviteServiceI
+}
+
+// data is everything that goes into an export
+type data struct {
+	GeneratedAt time.Time
+	User        *eligos.User
+	Spaces      []eligos.Space
+	Messages    []eligos.Message
+	Invites     []eligos.Invite
+	InvitesSent []eligos.Invite
+}
+
+// SpaceName returns the name of a joined space, used by the html index
+func (d *data) SpaceName(spaceid uuid.UUID) string {
+	for _, space := range d.Spaces {
+		if space.Id == spaceid {
+			return space.Name
+		}
+	}
+	return spaceid.String()
+}
+
+// WriteZip writes a zip archive of the user's data to w. Every kind of record is
+// stored as JSON, along with an index.html that presents it for people.
+func (e *Exporter) WriteZip(w io.Writer, userid uuid.UUID) error {
+	d, err := e.collect(userid)
+	if err != nil {
+		return err
+	}
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"user.json", d.User},
+		{"spaces.json", d.Spaces},
+		{"messages.json", d.Messages},
+		{"invites.json", d.Invites},
+		{"invites_sent.json", d.InvitesSent},
+	}
+	for _, file := range files {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(file.v)
+		if err != nil {
+			return err
+		}
+	}
+	f, err := archive.Create("index.html")
+	if err != nil {
+		return err
+	}
+	err = indexTemplate.Execute(f, d)
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func (e *Exporter) collect(userid uuid.UUID) (*data, error) {
+	user, err := e.UserService.GetUserById(userid)
+	if err != nil {
+		return nil, err
+	}
+	spaces, err := e.UserService.GetSpaces(userid)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := e.MessageService.GetMessagesByUser(userid)
+	if err != nil {
+		return nil, err
+	}
+	invites, err := e.InviteService.GetInvitesByUser(userid)
+	if err != nil {
+		return nil, err
+	}
+	sent, err := e.InviteService.GetInvitesSentBy(userid)
+	if err != nil {
+		return nil, err
+	}
+	return &data{
+		GeneratedAt: time.Now().UTC(),
+		User:        user,
+		Spaces:      *spaces,
+		Messages:    messages,
+		Invites:     invites,
+		InvitesSent: sent,
+	}, nil
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>eligos data export for {{.User.Name}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 2em auto; padding: 0 1em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+</style>
+</head>
+<body>
+<h1>eligos data export</h1>
+<p>Generated {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}}. The same data is in the JSON files next to this page.</p>
+
+<h2>Account</h2>
+<table>
+<tr><th>Id</th><td>{{.User.Id}}</td></tr>
+<tr><th>Name</th><td>{{.User.Name}}</td></tr>
+<tr><th>Email</th><td>{{.User.Email}}</td></tr>
+<tr><th>Email verified</th><td>{{.User.EmailVerified}}</td></tr>
+<tr><th>Two-factor authentication</th><td>{{.User.TotpEnabled}}</td></tr>
+</table>
+
+<h2>Spaces ({{len .Spaces}})</h2>
+<ul>
+{{range .Spaces}}<li>{{.Name}} <small>{{.Id}}</small></li>
+{{else}}<li>None</li>
+{{end}}</ul>
+
+<h2>Invites received ({{len .Invites}})</h2>
+<ul>
+{{range .Invites}}<li>{{.SpaceName}} <small>{{.SpaceId}}</small></li>
+{{else}}<li>None</li>
+{{end}}</ul>
+
+<h2>Invites sent ({{len .InvitesSent}})</h2>
+<table>
+<tr><th>Sent</th><th>Space</th><th>To</th><th>Expires</th></tr>
+{{range .InvitesSent}}<tr><td>{{.CreatedAt.Format "2006-01-02 15:04"}}</td><td>{{.SpaceName}}</td><td>{{.Email}}</td><td>{{.ExpiresAt.Format "2006-01-02 15:04"}}</td></tr>
+{{end}}</table>
+
+<h2>Messages ({{len .Messages}})</h2>
+<table>
+<tr><th>Sent</th><th>Space</th><th>Message</th></tr>
+{{range .Messages}}<tr><td>{{.CreatedAt.Format "2006-01-02 15:04"}}</td><td>{{$.SpaceName .SpaceId}}</td><td>{{.Body}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,168 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/arkreddy21/eligos"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Prefix of every api token, used to tell them apart from access tokens
+const apiTokenPrefix = "eligos_pat_"
+
+// authenticateApiToken looks up an api token and checks that it hasn't expired
+func (s *Server) authenticateApiToken(raw string) (*eligos.ApiToken, error) {
+	token, err := s.ApiTokenService.GetApiTokenByHash(hashToken(raw))
+	if err != nil {
+		return nil, err
+	}
+	if token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("api token expired")
+	}
+	s.ApiTokenService.TouchApiToken(token.Id)
+	return token, nil
+}
+
+// hasScope reports whether a request with the given scopes may do scope.
+// Login sessions have nil scopes and can do everything.
+func hasScope(scopes []string, scope string) bool {
+	return scopes == nil || slices.Contains(scopes, scope)
+}
+
+// requireScope rejects requests made with an api token that lacks scope
+func (s *Server) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, _ := r.Context().Value("scopes").([]string)
+			if !hasScope(scopes, scope) {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("api token is missing the " + scope + " scope"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireSession rejects requests made with an api token.
+// Used for account management, which scripts should never be able to do.
+func (s *Server) requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value("sessionId").(uuid.UUID); !ok {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("api tokens can't be used here"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleCreateApiToken(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	var body struct {
+		Name      string
+		Scopes    []string
+		ExpiresAt *time.Time
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if body.Name == "" || len([]rune(body.Name)) > 50 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("please provide a name of at most 50 characters"))
+		return
+	}
+	if len(body.Scopes) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("please provide at least one scope"))
+		return
+	}
+	for _, scope := range body.Scopes {
+		if !slices.Contains(eligos.Scopes, scope) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("unknown scope " + scope))
+			return
+		}
+	}
+	if body.ExpiresAt != nil && body.ExpiresAt.Before(time.Now()) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("expiry must be in the future"))
+		return
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not create api token"))
+		return
+	}
+	raw := apiTokenPrefix + secret
+	scopes := slices.Clone(body.Scopes)
+	slices.Sort(scopes)
+	token := &eligos.ApiToken{
+		UserId:    uid,
+		Name:      body.Name,
+		Hash:      hashToken(raw),
+		Scopes:    slices.Compact(scopes),
+		ExpiresAt: body.ExpiresAt,
+	}
+	err = s.ApiTokenService.CreateApiToken(token)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not create api token"))
+		return
+	}
+	// the raw token is only ever shown once
+	response, _ := json.Marshal(map[string]any{
+		"token":    raw,
+		"apiToken": token,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(response)
+}
+
+func (s *Server) handleGetApiTokens(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	tokens, err := s.ApiTokenService.GetApiTokensByUser(uid)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unable to get api tokens"))
+		return
+	}
+	response, _ := json.Marshal(tokens)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// handleDeleteApiToken revokes an api token and closes any websocket connection using it
+func (s *Server) handleDeleteApiToken(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	tokenid, err := uuid.Parse(chi.URLParam(r, "tokenid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid token id"))
+		return
+	}
+	err = s.ApiTokenService.DeleteApiToken(tokenid, uid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("api token not found"))
+		return
+	}
+	s.hub.CloseSession(tokenid)
+	w.WriteHeader(http.StatusNoContent)
+}
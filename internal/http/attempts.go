@@ -0,0 +1,98 @@
+package http
+
+import (
+	"github.com/arkreddy21/eligos"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// attemptPolicy decides how a key is slowed down as failures pile up.
+// After delayAfter failures every further failure locks the key for twice as
+// long as the last, up to maxAttemptDelay. After lockoutAfter failures the key
+// is locked for lockoutDuration.
+type attemptPolicy struct {
+	delayAfter   int
+	lockoutAfter int
+}
+
+const (
+	// Failures older than this are forgotten
+	attemptWindow = time.Hour
+
+	maxAttemptDelay = time.Minute
+	lockoutDuration = 15 * time.Minute
+)
+
+var (
+	// failed logins to a single account
+	accountPolicy = attemptPolicy{delayAfter: 3, lockoutAfter: 10}
+	// failed logins from a single ip, to any account
+	ipPolicy = attemptPolicy{delayAfter: 20, lockoutAfter: 100}
+	// registrations from a single ip, successful or not
+	registerPolicy = attemptPolicy{delayAfter: 5, lockoutAfter: 20}
+	// invites sent by a single user, each one mails an address they choose
+	invitePolicy = attemptPolicy{delayAfter: 20, lockoutAfter: 50}
+	// account emails asked for from a single ip, to any address
+	mailIpPolicy = attemptPolicy{delayAfter: 10, lockoutAfter: 30}
+	// account emails asked for to a single address
+	mailAddressPolicy = attemptPolicy{delayAfter: 3, lockoutAfter: 10}
+)
+
+// checkLockout responds with 429 Too Many Requests and returns false if any of the keys is locked out
+func (s *Server) checkLockout(w http.ResponseWriter, keys ...string) bool {
+	for _, key := range keys {
+		until, err := s.LoginAttemptService.GetLockout(key)
+		if err != nil {
+			log.Println("unable to check lockout: ", err)
+			continue
+		}
+		if wait := time.Until(until); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("too many attempts, try again later"))
+			return false
+		}
+	}
+	return true
+}
+
+// limitAccountEmail counts a request for an account email against both the ip and the
+// address it is sent to. Every request counts, whether or not the address is registered.
+func (s *Server) limitAccountEmail(w http.ResponseWriter, r *http.Request, action, email string) bool {
+	ipKey := eligos.IpAttemptKey(action, clientIp(r))
+	addressKey := eligos.AddressAttemptKey(action, email)
+	if !s.checkLockout(w, ipKey, addressKey) {
+		return false
+	}
+	s.recordFailedAttempt(ipKey, mailIpPolicy)
+	s.recordFailedAttempt(addressKey, mailAddressPolicy)
+	return true
+}
+
+// recordFailedAttempt counts a failure against key and locks it out according to policy
+func (s *Server) recordFailedAttempt(key string, policy attemptPolicy) {
+	failures, err := s.LoginAttemptService.RecordFailure(key, attemptWindow)
+	if err != nil {
+		log.Println("unable to record failed attempt: ", err)
+		return
+	}
+	var wait time.Duration
+	switch {
+	case failures >= policy.lockoutAfter:
+		wait = lockoutDuration
+	case failures > policy.delayAfter:
+		wait = maxAttemptDelay
+		if n := failures - policy.delayAfter - 1; n < 6 {
+			wait = min(time.Second<<n, maxAttemptDelay)
+		}
+	default:
+		return
+	}
+	err = s.LoginAttemptService.SetLockout(key, time.Now().Add(wait))
+	if err != nil {
+		log.Println("unable to lock out: ", err)
+	}
+}
@@ -0,0 +1,123 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/arkreddy21/eligos"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+// audit records an administrative action. A failure is logged but doesn't undo the action.
+func (s *Server) audit(spaceid, actorid uuid.UUID, targetid *uuid.UUID, action string, metadata map[string]any) {
+	err := s.AuditService.CreateAuditEntry(&eligos.AuditEntry{
+		SpaceId:  spaceid,
+		ActorId:  actorid,
+		TargetId: targetid,
+		Action:   action,
+		Metadata: metadata,
+	})
+	if err != nil {
+		log.Println("unable to record audit entry: ", err)
+	}
+}
+
+// handleGetAudit returns a page of the audit log of a space, newest first. It can be filtered
+// by actor, target, action and time. Pass the returned next value as before to get the next page.
+func (s *Server) handleGetAudit(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, spaceid, eligos.PermissionViewAudit); !ok {
+		return
+	}
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	filter.SpaceId = spaceid
+	entries, err := s.AuditService.GetAuditEntries(filter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unable to get audit log"))
+		return
+	}
+	// a full page means there may be more entries
+	var next *int64
+	if len(entries) == filter.Limit {
+		next = &entries[len(entries)-1].Id
+	}
+	response, _ := json.Marshal(map[string]any{
+		"entries": entries,
+		"next":    next,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func parseAuditFilter(r *http.Request) (eligos.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := eligos.AuditFilter{
+		Action: query.Get("action"),
+		Limit:  defaultAuditPageSize,
+	}
+	if v := query.Get("actor"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return filter, errors.New("invalid actor")
+		}
+		filter.ActorId = &id
+	}
+	if v := query.Get("target"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return filter, errors.New("invalid target")
+		}
+		filter.TargetId = &id
+	}
+	if v := query.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, errors.New("invalid since")
+		}
+		filter.Since = t
+	}
+	if v := query.Get("until"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, errors.New("invalid until")
+		}
+		filter.Until = t
+	}
+	if v := query.Get("before"); v != "" {
+		before, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || before <= 0 {
+			return filter, errors.New("invalid before")
+		}
+		filter.Before = before
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxAuditPageSize {
+			return filter, errors.New("invalid limit")
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
@@ -2,21 +2,37 @@ package http
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/arkreddy21/eligos"
+	"github.com/arkreddy21/eligos/internal/password"
 	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"log"
 	"net/http"
+	"net/mail"
 	"strings"
 	"time"
 )
 
 func (s *Server) authRoutes(r chi.Router) {
 	r.Post("/login", s.handleLogin)
+	r.Post("/login/totp", s.handleLoginTotp)
 	r.Post("/register", s.handleRegister)
+	r.Post("/refresh", s.handleRefresh)
+	r.Post("/verify", s.handleVerifyEmail)
+	r.Post("/resend-verification", s.handleResendVerification)
+	r.Post("/confirm-email", s.handleConfirmEmailChange)
+	r.Post("/forgot-password", s.handleForgotPassword)
+	r.Post("/reset-password", s.handleResetPassword)
+	r.With(s.validateJwt, s.requireSession).Post("/logout", s.handleLogout)
+	r.Route("/oidc", s.oidcRoutes)
 }
 
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -27,38 +43,82 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	email := r.Form.Get("email")
-	password := r.Form.Get("password")
-	if email == "" || password == "" {
+	pw := r.Form.Get("password")
+	if email == "" || pw == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("provide all input fields"))
 		return
 	}
+	accountKey := eligos.AccountAttemptKey(email)
+	ipKey := eligos.IpAttemptKey(eligos.AttemptLogin, clientIp(r))
+	if !s.checkLockout(w, accountKey, ipKey) {
+		return
+	}
 	user, err := s.UserService.GetUser(email)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("user not found"))
+		// compare against a dummy hash so unknown emails take as long as wrong passwords
+		password.Verify(pw, s.dummyPasswordHash())
+	}
+	if err != nil || !s.checkPassword(user, pw) {
+		// the same response for both cases, so logins can't be used to discover accounts
+		s.recordFailedAttempt(accountKey, accountPolicy)
+		s.recordFailedAttempt(ipKey, ipPolicy)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("email or password incorrect"))
 		return
 	}
-	if !CheckPasswordHash(password, user.Password) {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("password incorrect"))
+	s.LoginAttemptService.ResetAttempts(accountKey)
+	if !user.EmailVerified {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("email not verified"))
 		return
 	}
+	if user.TotpEnabled {
+		// the password was correct but the user still has to provide a second factor at /login/totp
+		totpToken, err := s.createUserToken(user.Id, eligos.TokenPurposeLoginTotp, user.Email, totpLoginTTL)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("could not login"))
+			return
+		}
+		response, _ := json.Marshal(map[string]any{
+			"message":   "totp required",
+			"totpToken": totpToken,
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+		return
+	}
+	s.login(w, r, user.Id)
+}
 
-	token, err := createToken(user.Id.String(), s.jwtKey)
+// login starts a new session for the user and responds with its tokens
+func (s *Server) login(w http.ResponseWriter, r *http.Request, userid uuid.UUID) {
+	token, refreshToken, err := s.createSession(r, userid)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("could not login"))
 		return
 	}
 	response, err := json.Marshal(map[string]any{
-		"message": "success",
-		"token":   token,
+		"message":      "success",
+		"token":        token,
+		"refreshToken": refreshToken,
 	})
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(response)
 }
 
+// parseEmail returns the bare address of email in lower case. Forms like "Name <name@example.com>"
+// are accepted but only the address is kept, since it is used to send mail and to find users.
+func parseEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
 func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -68,13 +128,35 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 	name := r.Form.Get("name")
 	email := r.Form.Get("email")
-	password := r.Form.Get("password")
-	if name == "" || email == "" || password == "" {
+	pw := r.Form.Get("password")
+	if name == "" || email == "" || pw == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("provide all input fields"))
 		return
 	}
-	hashedPassword, _ := HashPassword(password)
+	email, err = parseEmail(email)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid email address"))
+		return
+	}
+	// every registration counts as an attempt to limit mass account creation
+	ipKey := eligos.IpAttemptKey(eligos.AttemptRegister, clientIp(r))
+	if !s.checkLockout(w, ipKey) {
+		return
+	}
+	s.recordFailedAttempt(ipKey, registerPolicy)
+	if err := s.PasswordPolicy.Check(pw, email); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	hashedPassword, err := s.PasswordHasher.Hash(pw)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unable to create user"))
+		return
+	}
 	user := &eligos.User{
 		Name:     name,
 		Email:    email,
@@ -82,48 +164,95 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 	err = s.UserService.CreateUser(user)
 	if err != nil {
-		fmt.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Unable to create user. Check if email is already registered"))
-		return
+		existing, getErr := s.UserService.GetUser(email)
+		if getErr != nil {
+			fmt.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("unable to create user"))
+			return
+		}
+		// tell the owner of the address instead of the caller, so registration can't be used to discover accounts
+		err = s.sendAccountExistsEmail(existing)
+		if err != nil {
+			log.Println("unable to send account exists email: ", err)
+		}
+	} else {
+		err = s.sendVerificationEmail(user)
+		if err != nil {
+			log.Println("unable to send verification email: ", err)
+		}
 	}
-	w.Write([]byte("register successful"))
+	w.Write([]byte("register successful, check your email to verify your address"))
 }
 
-func (s *Server) handleUser(w http.ResponseWriter, r *http.Request) {
-	userId := r.Context().Value("userId").(string)
-	uid, _ := uuid.Parse(userId)
-	user, err := s.UserService.GetUserById(uid)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("user not found"))
-		return
+// checkPassword verifies the password of a user. A password hashed with an outdated
+// algorithm or cost is rehashed with the current settings.
+func (s *Server) checkPassword(user *eligos.User, pw string) bool {
+	if !password.Verify(pw, user.Password) {
+		return false
 	}
-	jsonResp, _ := json.Marshal(user)
-	w.Header().Add("Content-Type", "application/json")
-	w.Write(jsonResp)
+	if s.PasswordHasher.NeedsRehash(user.Password) {
+		err := s.setPassword(user.Id, pw)
+		if err != nil {
+			log.Println("unable to rehash password: ", err)
+		}
+	}
+	return true
 }
 
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+// dummyPasswordHash is a hash to check passwords against when a user doesn't exist
+func (s *Server) dummyPasswordHash() string {
+	s.dummyHashOnce.Do(func() {
+		s.dummyHash, _ = s.PasswordHasher.Hash("eligos")
+	})
+	return s.dummyHash
 }
 
-func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+// Audience of access tokens issued by eligos
+const tokenAudience = "eligos"
+
+// tokenClaims are the claims of an access token. The user id is the subject.
+// SessionId ties the token to a server-side session so that it can be revoked before it expires.
+type tokenClaims struct {
+	SessionId uuid.UUID `json:"sid"`
+	jwt.RegisteredClaims
 }
 
-func createToken(issuer string, key []byte) (string, error) {
-	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    issuer,
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 24 * 100)),
+func (s *Server) createToken(userid, sessionid uuid.UUID) (string, error) {
+	now := time.Now()
+	return s.keys.sign(tokenClaims{
+		SessionId: sessionid,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.tokenIssuer,
+			Subject:   userid.String(),
+			Audience:  jwt.ClaimStrings{tokenAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        uuid.NewString(),
+		},
 	})
-	token, err := claims.SignedString(key)
+}
+
+// authenticate parses an access token and checks that its session is still active
+func (s *Server) authenticate(authToken string) (*tokenClaims, error) {
+	token, err := jwt.ParseWithClaims(authToken, &tokenClaims{}, s.keys.keyfunc,
+		jwt.WithIssuer(s.tokenIssuer),
+		jwt.WithAudience(tokenAudience),
+		jwt.WithExpirationRequired(),
+	)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	claims := token.Claims.(*tokenClaims)
+	session, err := s.SessionService.GetSession(claims.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	if session.Revoked || session.ExpiresAt.Before(time.Now()) || session.UserId.String() != claims.Subject {
+		return nil, errors.New("session is no longer active")
 	}
-	return token, nil
+	s.SessionService.TouchSession(session.Id)
+	return claims, nil
 }
 
 func (s *Server) validateJwt(next http.Handler) http.Handler {
@@ -136,16 +265,42 @@ func (s *Server) validateJwt(next http.Handler) http.Handler {
 			return
 		}
 		authToken := authHeader[len(BearerSchema):]
-		token, err := jwt.ParseWithClaims(authToken, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return s.jwtKey, nil
-		})
+		if strings.HasPrefix(authToken, apiTokenPrefix) {
+			apiToken, err := s.authenticateApiToken(authToken)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("user unauthorized"))
+				return
+			}
+			ctx := context.WithValue(r.Context(), "userId", apiToken.UserId.String())
+			ctx = context.WithValue(ctx, "scopes", apiToken.Scopes)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		claims, err := s.authenticate(authToken)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			w.Write([]byte("user unauthorized"))
 			return
 		}
-		claims := token.Claims.(*jwt.RegisteredClaims)
-		ctx := context.WithValue(r.Context(), "userId", claims.Issuer)
+		ctx := context.WithValue(r.Context(), "userId", claims.Subject)
+		ctx = context.WithValue(ctx, "sessionId", claims.SessionId)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// randomToken returns a url safe random string with n bytes of entropy
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes a high entropy token for storage. Unlike passwords these
+// don't need a slow hash as they can't be brute forced.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,252 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/arkreddy21/eligos"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"net/http"
+	"regexp"
+	"slices"
+	"unicode/utf8"
+)
+
+// channel names are lowercase words like deploys or team-backend
+var channelNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+const maxChannelTopicLength = 250
+
+// handleGetChannels returns the channels of a space that the current user can read
+func (s *Server) handleGetChannels(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
+		return
+	}
+	member, err := s.SpaceService.GetMember(uid, spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("not a member of this space"))
+		return
+	}
+	channels, err := s.ChannelService.GetChannels(spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unable to get channels"))
+		return
+	}
+	visible := make([]eligos.Channel, 0, len(channels))
+	for _, channel := range channels {
+		if channel.MemberCan(member, eligos.PermissionReadMessages) {
+			visible = append(visible, channel)
+		}
+	}
+	response, _ := json.Marshal(visible)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func (s *Server) handleCreateChannel(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
+		return
+	}
+	var body struct {
+		Name      string
+		Topic     string
+		Overrides map[string]map[string]bool
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, spaceid, eligos.PermissionManageChannels); !ok {
+		return
+	}
+	if !s.checkNotArchived(w, spaceid) {
+		return
+	}
+	channel := &eligos.Channel{
+		SpaceId:   spaceid,
+		Name:      body.Name,
+		Topic:     body.Topic,
+		Overrides: body.Overrides,
+	}
+	if err := s.validateChannel(channel); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = s.ChannelService.CreateChannel(channel)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not create channel"))
+		return
+	}
+	s.audit(spaceid, uid, nil, eligos.AuditChannelCreated, map[string]any{"channelid": channel.Id, "name": channel.Name})
+	response, _ := json.Marshal(channel)
+	// members the overrides hide the channel from must not learn about it
+	s.hub.SendMessageToChannel(channel, "channel_created", response)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(response)
+}
+
+// handleUpdateChannel changes the name, topic or overrides of a channel. Fields left out are kept.
+func (s *Server) handleUpdateChannel(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	channel, ok := s.channelFromPath(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Name      *string
+		Topic     *string
+		Overrides map[string]map[string]bool
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, channel.SpaceId, eligos.PermissionManageChannels); !ok {
+		return
+	}
+	if !s.checkNotArchived(w, channel.SpaceId) {
+		return
+	}
+	if body.Name != nil {
+		channel.Name = *body.Name
+	}
+	if body.Topic != nil {
+		channel.Topic = *body.Topic
+	}
+	if body.Overrides != nil {
+		channel.Overrides = body.Overrides
+	}
+	if err := s.validateChannel(channel); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = s.ChannelService.UpdateChannel(channel)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not update channel"))
+		return
+	}
+	s.audit(channel.SpaceId, uid, nil, eligos.AuditChannelUpdated, map[string]any{"channelid": channel.Id, "name": channel.Name, "topic": channel.Topic, "overrides": channel.Overrides})
+	response, _ := json.Marshal(channel)
+	s.hub.SendMessageToChannel(channel, "channel_updated", response)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// handleDeleteChannel deletes a channel and its messages. The default channel can't be deleted.
+func (s *Server) handleDeleteChannel(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	channel, ok := s.channelFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, channel.SpaceId, eligos.PermissionManageChannels); !ok {
+		return
+	}
+	if !s.checkNotArchived(w, channel.SpaceId) {
+		return
+	}
+	if channel.IsDefault {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("the default channel can't be deleted"))
+		return
+	}
+	// only members who could read the channel knew it existed. They are found while
+	// the channel and its overrides are still there.
+	payload, _ := json.Marshal(map[string]uuid.UUID{"id": channel.Id})
+	s.hub.SendMessageToChannel(channel, "channel_deleted", payload)
+	err := s.ChannelService.DeleteChannel(channel.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not delete channel"))
+		return
+	}
+	s.audit(channel.SpaceId, uid, nil, eligos.AuditChannelDeleted, map[string]any{"channelid": channel.Id, "name": channel.Name})
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
+}
+
+// channelFromPath loads the channel in the url, checking that it belongs to the space in the url
+func (s *Server) channelFromPath(w http.ResponseWriter, r *http.Request) (*eligos.Channel, bool) {
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
+		return nil, false
+	}
+	channelid, err := uuid.Parse(chi.URLParam(r, "channelid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid channel id"))
+		return nil, false
+	}
+	channel, err := s.findChannel(spaceid, channelid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("channel not found"))
+		return nil, false
+	}
+	return channel, true
+}
+
+// validateChannel checks the fields of a new or changed channel, and that no other channel
+// in the space has its name
+func (s *Server) validateChannel(channel *eligos.Channel) error {
+	if !channelNamePattern.MatchString(channel.Name) {
+		return errors.New("channel names are 1-32 lowercase letters, digits, - or _")
+	}
+	if utf8.RuneCountInString(channel.Topic) > maxChannelTopicLength {
+		return errors.New("topic is too long")
+	}
+	for role, permissions := range channel.Overrides {
+		if !eligos.IsRole(role) || role == eligos.RoleOwner {
+			return errors.New("overrides can only be set for admin, member and guest")
+		}
+		for permission := range permissions {
+			if !slices.Contains(eligos.ChannelPermissions, permission) {
+				return errors.New("unknown permission " + permission)
+			}
+		}
+	}
+	channels, err := s.ChannelService.GetChannels(channel.SpaceId)
+	if err != nil {
+		return err
+	}
+	for _, other := range channels {
+		if other.Name == channel.Name && other.Id != channel.Id {
+			return errors.New("channel name is already taken")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/arkreddy21/eligos"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultDirectoryPageSize = 25
+	maxDirectoryPageSize     = 100
+)
+
+// handleDirectory lists public spaces with their member counts, optionally filtered by name.
+// Pass the returned next value as offset to get the next page.
+func (s *Server) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultDirectoryPageSize
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxDirectoryPageSize {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid limit"))
+			return
+		}
+		limit = n
+	}
+	offset := 0
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid offset"))
+			return
+		}
+		offset = n
+	}
+	spaces, err := s.SpaceService.GetPublicSpaces(query.Get("q"), limit, offset)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unable to get spaces"))
+		return
+	}
+	// a full page means there may be more spaces
+	var next *int
+	if len(spaces) == limit {
+		n := offset + limit
+		next = &n
+	}
+	response, _ := json.Marshal(map[string]any{
+		"spaces": spaces,
+		"next":   next,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// handleJoinSpace adds the current user to a public or unlisted space as a member
+func (s *Server) handleJoinSpace(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
+		return
+	}
+	space, err := s.SpaceService.GetSpace(spaceid)
+	// private spaces are reported as missing so their ids can't be probed
+	if err != nil || space.Kind != eligos.SpaceKindSpace || space.Visibility == eligos.VisibilityPrivate || space.ArchivedAt != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("space not found"))
+		return
+	}
+	if _, err := s.SpaceService.GetRole(uid, spaceid); err == nil {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("already a member of this space"))
+		return
+	}
+	if !s.checkNotBanned(w, uid, spaceid) {
+		return
+	}
+	err = s.SpaceService.AddUserById(uid, spaceid, eligos.RoleMember)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unable to join space"))
+		return
+	}
+	s.audit(spaceid, uid, &uid, eligos.AuditMemberJoined, map[string]any{"role": eligos.RoleMember})
+	payload, _ := json.Marshal(map[string]uuid.UUID{"spaceid": spaceid, "userid": uid})
+	s.hub.SendMessageToSpace(spaceid, "member_joined", payload)
+	response, _ := json.Marshal(space)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// handleSetVisibility changes who can find and join a space
+func (s *Server) handleSetVisibility(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
+		return
+	}
+	var body struct {
+		Visibility string
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !eligos.IsVisibility(body.Visibility) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("visibility must be private, public or unlisted"))
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, spaceid, eligos.PermissionManageSpace); !ok {
+		return
+	}
+	space, err := s.SpaceService.GetSpace(spaceid)
+	if err != nil || space.Kind != eligos.SpaceKindSpace {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("conversations can't be made public"))
+		return
+	}
+	err = s.SpaceService.SetVisibility(spaceid, body.Visibility)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not change visibility"))
+		return
+	}
+	s.audit(spaceid, uid, nil, eligos.AuditVisibilityChanged, map[string]any{"from": space.Visibility, "to": body.Visibility})
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
+}
@@ -0,0 +1,84 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/arkreddy21/eligos"
+	"github.com/google/uuid"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Most users a direct conversation can have, including its creator
+const maxDmParticipants = 10
+
+// handleOpenDm returns the direct conversation between the current user and the given users,
+// creating it the first time. The other participants are notified when it is created.
+func (s *Server) handleOpenDm(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	var body struct {
+		UserIds []uuid.UUID
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	participants := []uuid.UUID{uid}
+	for _, id := range body.UserIds {
+		if !slices.Contains(participants, id) {
+			participants = append(participants, id)
+		}
+	}
+	if len(participants) < 2 || len(participants) > maxDmParticipants {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("a conversation needs 1 to 9 other users"))
+		return
+	}
+	names := make(map[uuid.UUID]string, len(participants))
+	for _, id := range participants {
+		user, err := s.UserService.GetUserById(id)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("user not found"))
+			return
+		}
+		names[id] = user.Name
+	}
+	space, created, err := s.SpaceService.GetOrCreateDm(participants)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not open conversation"))
+		return
+	}
+	if created {
+		for _, id := range participants[1:] {
+			payload, _ := json.Marshal(eligos.Space{Id: space.Id, Kind: space.Kind, Name: dmName(names, id)})
+			s.hub.SendMessageToUser(id, "dm_created", payload)
+		}
+	}
+	space.Name = dmName(names, uid)
+	response, _ := json.Marshal(space)
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	w.Write(response)
+}
+
+// dmName is the name of a conversation as seen by one participant: the names of the others
+func dmName(names map[uuid.UUID]string, viewer uuid.UUID) string {
+	others := make([]string, 0, len(names))
+	for id, name := range names {
+		if id != viewer {
+			others = append(others, name)
+		}
+	}
+	slices.Sort(others)
+	return strings.Join(others, ", ")
+}
@@ -0,0 +1,159 @@
+package http
+
+import (
+	"fmt"
+	"github.com/arkreddy21/eligos"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Lifetime of the link sent to verify an email address
+const verifyEmailTTL = 24 * time.Hour
+
+// Lifetime of the link sent to confirm a new email address
+const changeEmailTTL = time.Hour
+
+// createUserToken stores a new single use token for the user and returns it
+func (s *Server) createUserToken(userid uuid.UUID, purpose, email string, ttl time.Duration) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = s.UserTokenService.CreateToken(&eligos.UserToken{
+		Hash:      hashToken(token),
+		UserId:    userid,
+		Purpose:   purpose,
+		Email:     email,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sendVerificationEmail mails a link that proves the user owns the email address
+func (s *Server) sendVerificationEmail(user *eligos.User) error {
+	token, err := s.createUserToken(user.Id, eligos.TokenPurposeVerifyEmail, user.Email, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	link := s.baseUrl + "/verify-email?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Hi %s,\n\nConfirm your email address for eligos by opening the link below:\n\n%s\n\nThe link expires in 24 hours. If you did not create an account you can ignore this email.\n", user.Name, link)
+	return s.Mailer.Send(user.Email, "Verify your email address", body)
+}
+
+func (s *Server) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to parse form"))
+		return
+	}
+	token, err := s.UserTokenService.ConsumeToken(hashToken(r.Form.Get("token")), eligos.TokenPurposeVerifyEmail)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid or expired token"))
+		return
+	}
+	user, err := s.UserService.GetUserById(token.UserId)
+	if err != nil || user.Email != token.Email {
+		// the address changed after the link was sent
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid or expired token"))
+		return
+	}
+	err = s.UserService.SetEmailVerified(user.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not verify email"))
+		return
+	}
+	s.attachInvites(user.Id, user.Email)
+	w.Write([]byte("email verified"))
+}
+
+// handleResendVerification mails a new verification link. The response is the same whether
+// or not the address is registered so it can't be used to discover accounts.
+func (s *Server) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to parse form"))
+		return
+	}
+	if !s.limitAccountEmail(w, r, eligos.AttemptVerifyEmail, r.Form.Get("email")) {
+		return
+	}
+	user, err := s.UserService.GetUser(r.Form.Get("email"))
+	if err == nil && !user.EmailVerified {
+		err = s.sendVerificationEmail(user)
+		if err != nil {
+			log.Println("unable to send verification email: ", err)
+		}
+	}
+	w.Write([]byte("if the address is registered and unverified, a verification email has been sent"))
+}
+
+// sendAccountExistsEmail lets the owner of an address know that someone tried to register it again
+func (s *Server) sendAccountExistsEmail(user *eligos.User) error {
+	body := fmt.Sprintf("Hi %s,\n\nSomeone tried to create a new eligos account with this email address, but you already have one. You can login at %s or reset your password at %s/forgot-password.\n\nIf this wasn't you, you can ignore this email.\n", user.Name, s.baseUrl, s.baseUrl)
+	return s.Mailer.Send(user.Email, "You already have an account", body)
+}
+
+// sendChangeEmail mails a confirmation link to the new address of a user.
+// The email is only changed once the link is opened.
+func (s *Server) sendChangeEmail(user *eligos.User, email string) error {
+	token, err := s.createUserToken(user.Id, eligos.TokenPurposeChangeEmail, email, changeEmailTTL)
+	if err != nil {
+		return err
+	}
+	link := s.baseUrl + "/confirm-email?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Hi %s,\n\nConfirm that you want to use this address for your eligos account by opening the link below:\n\n%s\n\nThe link expires in 1 hour. If you did not ask for this you can ignore this email.\n", user.Name, link)
+	return s.Mailer.Send(email, "Confirm your new email address", body)
+}
+
+// handleConfirmEmailChange changes the email of a user to the address a confirmation link was sent to
+func (s *Server) handleConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to parse form"))
+		return
+	}
+	token, err := s.UserTokenService.ConsumeToken(hashToken(r.Form.Get("token")), eligos.TokenPurposeChangeEmail)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid or expired token"))
+		return
+	}
+	user, err := s.UserService.GetUserById(token.UserId)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid or expired token"))
+		return
+	}
+	err = s.UserService.UpdateEmail(user.Id, token.Email)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("email is already in use"))
+		return
+	}
+	// reset links mailed to the old address must not work anymore
+	err = s.UserTokenService.DeleteTokens(user.Id, eligos.TokenPurposeResetPassword)
+	if err != nil {
+		log.Println("unable to delete reset tokens: ", err)
+	}
+	// opening the link proved the new address is theirs
+	s.attachInvites(user.Id, token.Email)
+	// let the old address know, in case the account was taken over
+	body := fmt.Sprintf("Hi %s,\n\nThe email address of your eligos account was changed to %s. If you did not do this, contact an administrator.\n", user.Name, token.Email)
+	err = s.Mailer.Send(user.Email, "Your email address was changed", body)
+	if err != nil {
+		log.Println("unable to send email changed notice: ", err)
+	}
+	w.Write([]byte("email changed"))
+}
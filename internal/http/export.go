@@ -0,0 +1,36 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/arkreddy21/eligos/internal/export"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"time"
+)
+
+// handleExport sends a zip archive of all the data stored about the current user
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	exporter := &export.Exporter{
+		UserService:    s.UserService,
+		MessageService: s.MessageService,
+		InviteService:  s.InviteService,
+	}
+	// built in memory first so a failure can still be reported with a status code
+	var buf bytes.Buffer
+	err := exporter.WriteZip(&buf, uid)
+	if err != nil {
+		log.Println("unable to export user data: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not export data"))
+		return
+	}
+	filename := fmt.Sprintf("eligos-export-%s.zip", time.Now().UTC().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
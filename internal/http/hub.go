@@ -8,6 +8,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"log"
+	"strings"
 	"time"
 )
 
@@ -15,24 +16,53 @@ import (
 // It receives messages from every client
 // and sends them only to the clients who need it
 type Hub struct {
-	// Registered clients.
-	clients map[uuid.UUID]*Client
+	// Registered clients by user id. A user can be connected from several sessions at once.
+	clients map[uuid.UUID]map[*Client]bool
 
 	// Inbound messages from the clients.
-	broadcast chan []byte
+	broadcast chan clientMessage
 
 	// register requests from the clients.
 	register chan *Client
 
 	// Unregister requests from clients.
-	unregister chan uuid.UUID
+	unregister chan *Client
+
+	// Session ids whose connections should be closed.
+	closeSession chan uuid.UUID
+
+	// User ids whose connections should be closed.
+	closeUser chan uuid.UUID
+
+	// Events from the server to send to every member of a space.
+	spaceEvents chan WsMessage
+
+	// Events from the server to send to the members who can read a channel.
+	channelEvents chan channelEvent
+
+	// Notifications from the server to send to a single user.
+	userEvents chan WsNotification
+}
+
+// channelEvent is an event about a channel that only its readers may see
+type channelEvent struct {
+	channel *eligos.Channel
+	message WsMessage
+}
+
+// clientMessage is a message read from a client's connection
+type clientMessage struct {
+	client *Client
+	data   []byte
 }
 
-// WsMessage is to send/receive messages in a space
+// WsMessage is to send/receive messages in a space.
+// Messages without a channel id go to the default channel of the space.
 type WsMessage struct {
-	Proto   string          `json:"proto"`
-	Spaceid uuid.UUID       `json:"spaceid"`
-	Payload json.RawMessage `json:"payload"`
+	Proto     string          `json:"proto"`
+	Spaceid   uuid.UUID       `json:"spaceid"`
+	Channelid uuid.UUID       `json:"channelid"`
+	Payload   json.RawMessage `json:"payload"`
 }
 
 // WsNotification is to send notifications to a user
@@ -44,10 +74,15 @@ type WsNotification struct {
 
 func newHub() *Hub {
 	return &Hub{
-		clients:    make(map[uuid.UUID]*Client),
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan uuid.UUID),
+		clients:       make(map[uuid.UUID]map[*Client]bool),
+		broadcast:     make(chan clientMessage),
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
+		closeSession:  make(chan uuid.UUID),
+		closeUser:     make(chan uuid.UUID),
+		spaceEvents:   make(chan WsMessage),
+		channelEvents: make(chan channelEvent),
+		userEvents:    make(chan WsNotification),
 	}
 }
 
@@ -55,62 +90,122 @@ func (h *Hub) run(s *Server) {
 	for {
 		select {
 		case client := <-h.register:
-			h.clients[client.id] = client
-		case clientid := <-h.unregister:
-			if val, ok := h.clients[clientid]; ok {
-				close(val.send)
-				delete(h.clients, clientid)
+			if h.clients[client.id] == nil {
+				h.clients[client.id] = make(map[*Client]bool)
+			}
+			h.clients[client.id][client] = true
+		case client := <-h.unregister:
+			h.removeClient(client)
+		case sessionid := <-h.closeSession:
+			for _, clients := range h.clients {
+				for client := range clients {
+					if client.session == sessionid {
+						h.removeClient(client)
+					}
+				}
+			}
+		case userid := <-h.closeUser:
+			for client := range h.clients[userid] {
+				h.removeClient(client)
 			}
 		case message := <-h.broadcast:
 			var data WsMessage
-			err := json.Unmarshal(message, &data)
+			err := json.Unmarshal(message.data, &data)
 			if err != nil {
 				continue
 			}
-			res, err := handleRequest(data.Payload, data.Proto, s)
+			channel, err := s.findChannel(data.Spaceid, data.Channelid)
 			if err != nil {
+				h.sendError(message.client, data.Spaceid, "channel not found")
 				continue
 			}
-			response, err := json.Marshal(WsMessage{
-				Proto:   data.Proto,
-				Spaceid: data.Spaceid,
-				Payload: res,
-			})
-			users, err := s.SpaceService.GetUsersInSpace(data.Spaceid)
-			if err != nil {
+			if space, err := s.SpaceService.GetSpace(channel.SpaceId); err != nil || space.ArchivedAt != nil {
+				h.sendError(message.client, data.Spaceid, "this space is archived")
 				continue
 			}
-			//TODO O(users x clients) not efficient
-			for _, user := range *users {
-				client, ok := h.clients[user.Id]
-				if !ok {
-					// user is not connected
-					continue
-				}
-				//client.send <- response
-				select {
-				case client.send <- response:
-				default:
-					close(client.send)
-					delete(h.clients, client.id)
-				}
+			if !s.canInChannel(message.client.id, channel, eligos.PermissionPostMessages) {
+				h.sendError(message.client, data.Spaceid, "you can't post in this channel")
+				continue
 			}
+			res, err := handleRequest(message.client, channel, data, s)
+			if err != nil {
+				h.sendError(message.client, data.Spaceid, err.Error())
+				continue
+			}
+			h.sendToChannel(s, channel, WsMessage{
+				Proto:     data.Proto,
+				Spaceid:   channel.SpaceId,
+				Channelid: channel.Id,
+				Payload:   res,
+			})
+		case event := <-h.spaceEvents:
+			h.sendToSpace(s, event)
+		case event := <-h.channelEvents:
+			h.sendToChannel(s, event.channel, event.message)
+		case notification := <-h.userEvents:
+			h.sendToUser(notification)
 		}
 	}
 }
 
-// takes payload and proto from the websocket message and returns the appropriate payload to send back
-func handleRequest(payload json.RawMessage, proto string, s *Server) (json.RawMessage, error) {
-	switch proto {
+// sendToChannel sends a message to the connected clients of every member who can read its channel
+func (h *Hub) sendToChannel(s *Server, channel *eligos.Channel, message WsMessage) {
+	response, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	members, err := s.SpaceService.GetMembers(channel.SpaceId)
+	if err != nil {
+		return
+	}
+	for _, member := range members {
+		if !channel.MemberCan(&member, eligos.PermissionReadMessages) {
+			continue
+		}
+		for client := range h.clients[member.UserId] {
+			h.sendToClient(client, response)
+		}
+	}
+}
+
+// sendToSpace sends a message to the connected clients of every member of its space
+func (h *Hub) sendToSpace(s *Server, message WsMessage) {
+	response, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	users, err := s.SpaceService.GetUsersInSpace(message.Spaceid)
+	if err != nil {
+		return
+	}
+	//TODO O(users x clients) not efficient
+	for _, user := range *users {
+		// clients is empty if the user is not connected
+		for client := range h.clients[user.Id] {
+			h.sendToClient(client, response)
+		}
+	}
+}
+
+// takes a websocket message from a client and returns the appropriate payload to send back.
+// Only the content comes from the client, the author is always the authenticated user.
+func handleRequest(client *Client, channel *eligos.Channel, data WsMessage, s *Server) (json.RawMessage, error) {
+	switch data.Proto {
 	case "message":
-		var m eligos.MessageWUser
-		err := json.Unmarshal(payload, &m)
+		var m struct {
+			Body string `json:"body"`
+		}
+		err := json.Unmarshal(data.Payload, &m)
 		if err != nil {
 			return nil, err
 		}
-		message, err := s.MessageService.CreateMessage(m)
+		if strings.TrimSpace(m.Body) == "" {
+			return nil, fmt.Errorf("message is empty")
+		}
+		message, err := s.MessageService.CreateMessage(client.id, channel.Id, m.Body)
 		if err != nil {
-			return nil, err
+			log.Println("unable to create message: ", err)
+			return nil, fmt.Errorf("could not send message")
 		}
 		response, err := json.Marshal(message)
 		if err != nil {
@@ -122,27 +217,98 @@ func handleRequest(payload json.RawMessage, proto string, s *Server) (json.RawMe
 	}
 }
 
-// SendMessageToUser sends message to a particular user outside of spaces.
-// useful for sending notifications, invites etc
-func (h *Hub) SendMessageToUser(userId uuid.UUID, proto string, payload []byte) {
-	client, ok := h.clients[userId]
+// sendError tells a client that a message it sent was rejected
+func (h *Hub) sendError(client *Client, spaceid uuid.UUID, reason string) {
+	if !h.clients[client.id][client] {
+		// the client was disconnected after it sent the message
+		return
+	}
+	payload, _ := json.Marshal(map[string]string{"error": reason})
+	res, err := json.Marshal(WsMessage{
+		Proto:   "error",
+		Spaceid: spaceid,
+		Payload: payload,
+	})
+	if err != nil {
+		return
+	}
+	h.sendToClient(client, res)
+}
+
+// sendToUser sends a notification to the connected clients of its user
+func (h *Hub) sendToUser(notification WsNotification) {
+	clients, ok := h.clients[notification.Userid]
 	if !ok {
 		return
 	}
+	res, err := json.Marshal(notification)
+	if err != nil {
+		return
+	}
+	for client := range clients {
+		h.sendToClient(client, res)
+	}
+}
 
-	res, err := json.Marshal(WsNotification{
+// SendMessageToUser sends message to a particular user outside of spaces.
+// useful for sending notifications, invites etc
+func (h *Hub) SendMessageToUser(userId uuid.UUID, proto string, payload []byte) {
+	h.userEvents <- WsNotification{
 		Proto:   proto,
 		Userid:  userId,
 		Payload: payload,
-	})
-	if err != nil {
-		return
 	}
+}
+
+// SendMessageToSpace sends an event from the server to every member of a space
+func (h *Hub) SendMessageToSpace(spaceId uuid.UUID, proto string, payload []byte) {
+	h.spaceEvents <- WsMessage{
+		Proto:   proto,
+		Spaceid: spaceId,
+		Payload: payload,
+	}
+}
+
+// SendMessageToChannel sends an event from the server to the members who can read a channel
+func (h *Hub) SendMessageToChannel(channel *eligos.Channel, proto string, payload []byte) {
+	h.channelEvents <- channelEvent{
+		channel: channel,
+		message: WsMessage{
+			Proto:     proto,
+			Spaceid:   channel.SpaceId,
+			Channelid: channel.Id,
+			Payload:   payload,
+		},
+	}
+}
+
+// CloseSession disconnects every client that was authenticated with the given session
+func (h *Hub) CloseSession(sessionId uuid.UUID) {
+	h.closeSession <- sessionId
+}
+
+// CloseUser disconnects every client of a user
+func (h *Hub) CloseUser(userId uuid.UUID) {
+	h.closeUser <- userId
+}
 
+// sendToClient queues a message for a client, dropping the client if its buffer is full
+func (h *Hub) sendToClient(client *Client, message []byte) {
 	select {
-	case client.send <- res:
+	case client.send <- message:
 	default:
-		close(client.send)
+		h.removeClient(client)
+	}
+}
+
+func (h *Hub) removeClient(client *Client) {
+	clients, ok := h.clients[client.id]
+	if !ok || !clients[client] {
+		return
+	}
+	close(client.send)
+	delete(clients, client)
+	if len(clients) == 0 {
 		delete(h.clients, client.id)
 	}
 }
@@ -175,6 +341,12 @@ type Client struct {
 	//user id
 	id uuid.UUID
 
+	// id of the session or api token the connection was authenticated with
+	session uuid.UUID
+
+	// scopes of the api token the connection was authenticated with, nil for a login session
+	scopes []string
+
 	// Buffered channel of outbound messages.
 	send chan []byte
 }
@@ -186,7 +358,7 @@ type Client struct {
 // reads from this goroutine.
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c.id
+		c.hub.unregister <- c
 		c.conn.Close()
 	}()
 	c.conn.SetReadLimit(maxMessageSize)
@@ -200,8 +372,12 @@ func (c *Client) readPump() {
 			}
 			break
 		}
+		if !hasScope(c.scopes, eligos.ScopeMessagesWrite) {
+			// read only api token
+			continue
+		}
 		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
-		c.hub.broadcast <- message
+		c.hub.broadcast <- clientMessage{client: c, data: message}
 	}
 }
 
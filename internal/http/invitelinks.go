@@ -0,0 +1,227 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/arkreddy21/eligos"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// handleCreateInviteLink creates a link anyone can use to join the space. The token is only
+// returned here, like api tokens only its hash is stored.
+func (s *Server) handleCreateInviteLink(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
+		return
+	}
+	var body struct {
+		Role      string
+		MaxUses   *int
+		ExpiresAt *time.Time
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, spaceid, eligos.PermissionAddMembers); !ok {
+		return
+	}
+	if !s.checkNotArchived(w, spaceid) {
+		return
+	}
+	if body.Role == "" {
+		body.Role = eligos.RoleMember
+	}
+	if body.Role != eligos.RoleMember && body.Role != eligos.RoleGuest {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invite links can only give the member or guest role"))
+		return
+	}
+	if body.MaxUses != nil && *body.MaxUses < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("max uses must be at least 1"))
+		return
+	}
+	if body.ExpiresAt != nil && body.ExpiresAt.Before(time.Now()) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("expiry must be in the future"))
+		return
+	}
+	token, err := randomToken(16)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not create invite link"))
+		return
+	}
+	link := &eligos.InviteLink{
+		SpaceId:   spaceid,
+		Hash:      hashToken(token),
+		Role:      body.Role,
+		MaxUses:   body.MaxUses,
+		ExpiresAt: body.ExpiresAt,
+		CreatedBy: &uid,
+	}
+	err = s.InviteLinkService.CreateInviteLink(link)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not create invite link"))
+		return
+	}
+	s.audit(spaceid, uid, nil, eligos.AuditInviteLinkCreated, map[string]any{"linkid": link.Id, "role": link.Role, "maxUses": link.MaxUses, "expiresAt": link.ExpiresAt})
+	response, _ := json.Marshal(map[string]any{
+		"token":      token,
+		"url":        s.baseUrl + "/invite/" + url.PathEscape(token),
+		"inviteLink": link,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(response)
+}
+
+func (s *Server) handleGetInviteLinks(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, spaceid, eligos.PermissionAddMembers); !ok {
+		return
+	}
+	links, err := s.InviteLinkService.GetInviteLinks(spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unable to get invite links"))
+		return
+	}
+	response, _ := json.Marshal(links)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func (s *Server) handleRevokeInviteLink(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
+		return
+	}
+	linkid, err := uuid.Parse(chi.URLParam(r, "linkid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid invite link id"))
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, spaceid, eligos.PermissionAddMembers); !ok {
+		return
+	}
+	err = s.InviteLinkService.DeleteInviteLink(linkid, spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("invite link not found"))
+		return
+	}
+	s.audit(spaceid, uid, nil, eligos.AuditInviteLinkRevoked, map[string]any{"linkid": linkid})
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
+}
+
+// findInviteLink returns the link for the token in the url and its space, or writes a 404
+// if the link can't be used
+func (s *Server) findInviteLink(w http.ResponseWriter, r *http.Request) (*eligos.InviteLink, *eligos.Space, bool) {
+	link, err := s.InviteLinkService.GetInviteLinkByHash(hashToken(chi.URLParam(r, "token")))
+	if err == nil && link.Usable() {
+		space, err := s.SpaceService.GetSpace(link.SpaceId)
+		if err == nil && space.ArchivedAt == nil {
+			return link, space, true
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte("invite link is invalid or expired"))
+	return nil, nil, false
+}
+
+// handleInviteLinkPreview shows what space a link leads to. It doesn't need a login,
+// so people can see where they are invited before signing up.
+func (s *Server) handleInviteLinkPreview(w http.ResponseWriter, r *http.Request) {
+	link, space, ok := s.findInviteLink(w, r)
+	if !ok {
+		return
+	}
+	members, err := s.SpaceService.GetMembers(space.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unable to get space"))
+		return
+	}
+	response, _ := json.Marshal(map[string]any{
+		"spaceid":     space.Id,
+		"name":        space.Name,
+		"description": space.Description,
+		"icon":        space.Icon,
+		"memberCount": len(members),
+		"role":        link.Role,
+		"expiresAt":   link.ExpiresAt,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// handleRedeemInviteLink adds the current user to the space of an invite link
+func (s *Server) handleRedeemInviteLink(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	link, space, ok := s.findInviteLink(w, r)
+	if !ok {
+		return
+	}
+	user, err := s.UserService.GetUserById(uid)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user not found"))
+		return
+	}
+	if !user.EmailVerified {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("email not verified"))
+		return
+	}
+	if _, err := s.SpaceService.GetRole(uid, space.Id); err == nil {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("already a member of this space"))
+		return
+	}
+	if !s.checkNotBanned(w, uid, space.Id) {
+		return
+	}
+	err = s.InviteLinkService.RedeemInviteLink(link.Id, uid)
+	if err != nil {
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte("invite link is invalid or expired"))
+		return
+	}
+	s.audit(space.Id, uid, &uid, eligos.AuditInviteLinkRedeemed, map[string]any{"linkid": link.Id, "role": link.Role})
+	payload, _ := json.Marshal(map[string]uuid.UUID{"spaceid": space.Id, "userid": uid})
+	s.hub.SendMessageToSpace(space.Id, "member_joined", payload)
+	response, _ := json.Marshal(space)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
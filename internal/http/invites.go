@@ -2,113 +2,326 @@ package http
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/arkreddy21/eligos"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"log"
 	"net/http"
+	"net/url"
+	"time"
 )
 
+// Lifetime of an invite when the inviter doesn't choose one
+const inviteTTL = 7 * 24 * time.Hour
+
+// Longest an invite can be kept open for
+const maxInviteTTL = 30 * 24 * time.Hour
+
 func (s *Server) inviteRoutes(r chi.Router) {
+	r.Use(s.requireScope(eligos.ScopeSpacesManage))
 	r.Post("/create", s.handleInviteCreate)
 	r.Post("/accept", s.handleInviteAccept)
 	r.Post("/reject", s.handleInviteReject)
 	r.Get("/get", s.handleGetInvites)
+	r.Post("/link/{token}/redeem", s.handleRedeemInviteLink)
 }
 
+// handleInviteCreate invites an email address to a space. The address doesn't need an
+// account yet, the invite waits for whoever registers and verifies it.
 func (s *Server) handleInviteCreate(w http.ResponseWriter, r *http.Request) {
-	var invite eligos.Invite
-	err := json.NewDecoder(r.Body).Decode(&invite)
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	var body struct {
+		SpaceId   uuid.UUID
+		Email     string
+		ExpiresAt *time.Time
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	err := json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
-	user, err := s.UserService.GetUser(invite.Email)
+	invite := eligos.Invite{
+		SpaceId:   body.SpaceId,
+		Email:     body.Email,
+		InvitedBy: &uid,
+		ExpiresAt: time.Now().Add(inviteTTL),
+	}
+	if body.ExpiresAt != nil {
+		if body.ExpiresAt.Before(time.Now()) || body.ExpiresAt.After(time.Now().Add(maxInviteTTL)) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("expiry must be in the future and within 30 days"))
+			return
+		}
+		invite.ExpiresAt = *body.ExpiresAt
+	}
+	invite.Email, err = parseEmail(invite.Email)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		w.Write([]byte("invalid email address"))
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, invite.SpaceId, eligos.PermissionAddMembers); !ok {
+		return
+	}
+	// every invite mails an address of the caller's choosing, so they are limited like registrations
+	userKey := eligos.UserAttemptKey(eligos.AttemptInvite, uid)
+	if !s.checkLockout(w, userKey) {
 		return
 	}
+	s.recordFailedAttempt(userKey, invitePolicy)
+	if !s.checkNotArchived(w, invite.SpaceId) {
+		return
+	}
+	space, err := s.SpaceService.GetSpace(invite.SpaceId)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("space not found"))
+		return
+	}
+	invite.SpaceName = space.Name
+	// the response must look the same whether the address has an account or not, so invites
+	// to members and banned users are created like any other but never reach them
+	notify := true
+	user, err := s.UserService.GetUser(invite.Email)
+	if err == nil {
+		_, memberErr := s.SpaceService.GetRole(user.Id, invite.SpaceId)
+		banned, banErr := s.BanService.IsBanned(user.Id, invite.SpaceId)
+		if memberErr == nil || banned || banErr != nil {
+			notify = false
+		} else if user.EmailVerified {
+			// unverified accounts get the invite when they verify the address
+			invite.UserId = &user.Id
+		}
+	}
 	err = s.InviteService.CreateInvite(&invite)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusConflict)
 		w.Write([]byte(err.Error()))
 		return
 	}
+	s.audit(invite.SpaceId, uid, nil, eligos.AuditInviteCreated, map[string]any{"inviteid": invite.Id, "email": invite.Email, "expiresAt": invite.ExpiresAt})
+	response, _ := json.Marshal(invite)
+	if notify {
+		err = s.sendInviteEmail(&invite, uid)
+		if err != nil {
+			log.Println("unable to send invite email: ", err)
+		}
+		if invite.UserId != nil {
+			s.hub.SendMessageToUser(*invite.UserId, "invite", response)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	wsPayload, err := json.Marshal(invite)
+	w.Write(response)
+}
+
+// sendInviteEmail lets the invited address know about an invite. People without an
+// account are asked to register with that address to see it.
+func (s *Server) sendInviteEmail(invite *eligos.Invite, inviterid uuid.UUID) error {
+	inviter := "Someone"
+	if user, err := s.UserService.GetUserById(inviterid); err == nil {
+		inviter = user.Name
+	}
+	var body string
+	if invite.UserId != nil {
+		body = fmt.Sprintf("Hi,\n\n%s invited you to join %s on eligos. Login at %s to accept or reject the invite before %s.\n", inviter, invite.SpaceName, s.baseUrl, invite.ExpiresAt.Format("2 January 2006"))
+	} else {
+		link := s.baseUrl + "/register?email=" + url.QueryEscape(invite.Email)
+		body = fmt.Sprintf("Hi,\n\n%s invited you to join %s on eligos. Create an account with this email address to accept the invite:\n\n%s\n\nThe invite will be waiting once you verify your address, until %s. If you don't know who sent this you can ignore this email.\n", inviter, invite.SpaceName, link, invite.ExpiresAt.Format("2 January 2006"))
+	}
+	return s.Mailer.Send(invite.Email, "You are invited to "+invite.SpaceName, body)
+}
+
+// attachInvites hands the invites waiting for a newly verified address to its user
+func (s *Server) attachInvites(userid uuid.UUID, email string) {
+	invites, err := s.InviteService.AttachInvites(email, userid)
 	if err != nil {
+		log.Println("unable to attach invites: ", err)
 		return
 	}
-	s.hub.SendMessageToUser(user.Id, "invite", wsPayload)
+	for _, invite := range invites {
+		wsPayload, err := json.Marshal(invite)
+		if err != nil {
+			continue
+		}
+		s.hub.SendMessageToUser(userid, "invite", wsPayload)
+	}
 }
 
-func (s *Server) handleInviteAccept(w http.ResponseWriter, r *http.Request) {
-	var invite eligos.Invite
-	err := json.NewDecoder(r.Body).Decode(&invite)
+// findOwnInvite returns an invite attached to the user, or writes a 404. Invites of other
+// users look the same as ones that don't exist.
+func (s *Server) findOwnInvite(w http.ResponseWriter, r *http.Request, userid uuid.UUID) (*eligos.Invite, bool) {
+	var body struct {
+		Id uuid.UUID
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	err := json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return nil, false
+	}
+	invite, err := s.InviteService.GetInviteById(body.Id)
+	if err != nil || invite.UserId == nil || *invite.UserId != userid {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("invite not found"))
+		return nil, false
+	}
+	return invite, true
+}
+
+// handleInviteAccept adds the current user to the space of one of their invites
+func (s *Server) handleInviteAccept(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	invite, ok := s.findOwnInvite(w, r, uid)
+	if !ok {
 		return
 	}
-	user, err := s.UserService.GetUser(invite.Email)
+	if invite.Expired() {
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte("invite has expired"))
+		return
+	}
+	if !s.checkNotArchived(w, invite.SpaceId) {
+		return
+	}
+	if !s.checkNotBanned(w, uid, invite.SpaceId) {
+		return
+	}
+	err := s.InviteService.AcceptInvite(invite.Id, eligos.RoleMember)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
-	err = s.SpaceService.AddUserById(user.Id, invite.SpaceId)
+	s.audit(invite.SpaceId, uid, &uid, eligos.AuditInviteAccepted, map[string]any{"inviteid": invite.Id, "invitedBy": invite.InvitedBy, "role": eligos.RoleMember})
+	payload, _ := json.Marshal(map[string]uuid.UUID{"spaceid": invite.SpaceId, "userid": uid})
+	s.hub.SendMessageToSpace(invite.SpaceId, "member_joined", payload)
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
+}
+
+// handleInviteReject declines one of the current user's invites
+func (s *Server) handleInviteReject(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	invite, ok := s.findOwnInvite(w, r, uid)
+	if !ok {
+		return
+	}
+	err := s.InviteService.DeleteInviteById(invite.Id)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
-	err = s.InviteService.DeleteInviteById(invite.Id)
+	s.audit(invite.SpaceId, uid, &uid, eligos.AuditInviteRejected, map[string]any{"inviteid": invite.Id})
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
+}
+
+// handleGetInvites lists the pending invites of the current user
+func (s *Server) handleGetInvites(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	invites, err := s.InviteService.GetInvitesByUser(uid)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
+	res, err := json.Marshal(invites)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
 }
 
-func (s *Server) handleInviteReject(w http.ResponseWriter, r *http.Request) {
-	var invite eligos.Invite
-	err := json.NewDecoder(r.Body).Decode(&invite)
+// handleGetSpaceInvites lists the pending invites sent for a space
+func (s *Server) handleGetSpaceInvites(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
 		return
 	}
-	err = s.InviteService.DeleteInviteById(invite.Id)
+	if _, ok := s.authorizeSpace(w, uid, spaceid, eligos.PermissionAddMembers); !ok {
+		return
+	}
+	invites, err := s.InviteService.GetInvitesBySpace(spaceid)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		w.Write([]byte("unable to get invites"))
 		return
 	}
+	response, _ := json.Marshal(invites)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
 }
 
-func (s *Server) handleGetInvites(w http.ResponseWriter, r *http.Request) {
-	email, ok := r.URL.Query()["email"]
-	if !ok {
+// handleRevokeInvite withdraws an invite. Members can revoke the invites they sent, even
+// if their role no longer lets them invite. Admins and owners can revoke any invite to the space.
+func (s *Server) handleRevokeInvite(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("email query param not found"))
+		w.Write([]byte("invalid space id"))
 		return
 	}
-	user, err := s.UserService.GetUser(email[0])
+	inviteid, err := uuid.Parse(chi.URLParam(r, "inviteid"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		w.Write([]byte("invalid invite id"))
 		return
 	}
-	invites, err := s.InviteService.GetInvitesByUser(user.Email)
+	// the inviter may have lost the right to invite since, they can still take their invite back
+	role, err := s.SpaceService.GetRole(uid, spaceid)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("not a member of this space"))
 		return
 	}
-	res, err := json.Marshal(invites)
+	invite, err := s.InviteService.GetInviteById(inviteid)
+	if err != nil || invite.SpaceId != spaceid {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("invite not found"))
+		return
+	}
+	sentByCaller := invite.InvitedBy != nil && *invite.InvitedBy == uid
+	if !sentByCaller && !eligos.RoleCan(role, eligos.PermissionManageRoles) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("only the inviter or an admin can revoke this invite"))
+		return
+	}
+	err = s.InviteService.DeleteInviteById(invite.Id)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		w.Write([]byte("could not revoke invite"))
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(res)
+	s.audit(spaceid, uid, invite.UserId, eligos.AuditInviteRevoked, map[string]any{"inviteid": invite.Id, "email": invite.Email, "invitedBy": invite.InvitedBy})
+	if invite.UserId != nil {
+		payload, _ := json.Marshal(map[string]uuid.UUID{"id": invite.Id, "spaceid": spaceid})
+		s.hub.SendMessageToUser(*invite.UserId, "invite_revoked", payload)
+	}
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
 }
@@ -0,0 +1,179 @@
+package http
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// signingKey is a key access tokens are signed or verified with
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	// ed25519.PrivateKey, *rsa.PrivateKey or []byte for HS256
+	private any
+	// time after which tokens signed with a retired key are no longer accepted, zero for the active key
+	expiresAt time.Time
+}
+
+// keySet holds the key new tokens are signed with and older keys that are still accepted
+type keySet struct {
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+// Layout of key ids. genkey names every key after the time it was created, which
+// is also when it starts signing.
+const keyIdLayout = "20060102T150405Z"
+
+// loadKeySet reads every <kid>.pem private key in dir, where kid is the creation time
+// of the key. The key named by activeKid signs new tokens, or the newest key if
+// activeKid is empty. The other keys are retired: they are still accepted until grace
+// after the creation time of the active key, so tokens issued just before a rotation
+// keep working. Only the names decide this, not file timestamps that change when keys
+// are copied or restored.
+func loadKeySet(dir, activeKid string, grace time.Duration) (*keySet, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	set := &keySet{keys: make(map[string]*signingKey)}
+	var activeCreated time.Time
+	for _, file := range files {
+		key, err := loadKey(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		created, err := time.Parse(keyIdLayout, key.kid)
+		if err != nil {
+			return nil, fmt.Errorf("%s: key files must be named after their creation time, like %s.pem", file, keyIdLayout)
+		}
+		set.keys[key.kid] = key
+		if key.kid == activeKid || (activeKid == "" && created.After(activeCreated)) {
+			set.active, activeCreated = key, created
+		}
+	}
+	if set.active == nil {
+		return nil, fmt.Errorf("no active signing key found in %s", dir)
+	}
+	for _, key := range set.keys {
+		if key != set.active {
+			key.expiresAt = activeCreated.Add(grace)
+		}
+	}
+	return set, nil
+}
+
+func loadKey(file string) (*signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	var private any
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := &signingKey{kid: strings.TrimSuffix(filepath.Base(file), ".pem"), private: private}
+	switch private.(type) {
+	case ed25519.PrivateKey:
+		key.method = jwt.SigningMethodEdDSA
+	case *rsa.PrivateKey:
+		key.method = jwt.SigningMethodRS256
+	default:
+		return nil, errors.New("only Ed25519 and RSA keys are supported")
+	}
+	return key, nil
+}
+
+// newHmacKeySet uses a single shared secret. Tokens signed this way can only be
+// verified by services holding the secret, so nothing is published in the jwks.
+func newHmacKeySet(secret []byte) *keySet {
+	key := &signingKey{kid: "hs256", method: jwt.SigningMethodHS256, private: secret}
+	return &keySet{active: key, keys: map[string]*signingKey{key.kid: key}}
+}
+
+func (k *signingKey) verificationKey() any {
+	if signer, ok := k.private.(crypto.Signer); ok {
+		return signer.Public()
+	}
+	return k.private
+}
+
+func (set *keySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(set.active.method, claims)
+	token.Header["kid"] = set.active.kid
+	return token.SignedString(set.active.private)
+}
+
+// keyfunc picks the verification key by the kid header of a token
+func (set *keySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := set.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("signing method does not match key")
+	}
+	if !key.expiresAt.IsZero() && key.expiresAt.Before(time.Now()) {
+		return nil, fmt.Errorf("key %q has been retired", kid)
+	}
+	return key.verificationKey(), nil
+}
+
+// jwk returns the public key as a JSON Web Key, or nil for symmetric keys
+func (k *signingKey) jwk() map[string]string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwk := map[string]string{"kid": k.kid, "use": "sig", "alg": k.method.Alg()}
+	switch public := k.verificationKey().(type) {
+	case ed25519.PublicKey:
+		jwk["kty"], jwk["crv"], jwk["x"] = "OKP", "Ed25519", b64(public)
+	case *rsa.PublicKey:
+		jwk["kty"], jwk["n"], jwk["e"] = "RSA", b64(public.N.Bytes()), b64(big.NewInt(int64(public.E)).Bytes())
+	default:
+		return nil
+	}
+	return jwk
+}
+
+// handleJwks publishes the public keys access tokens can be verified with
+func (s *Server) handleJwks(w http.ResponseWriter, r *http.Request) {
+	keys := make([]map[string]string, 0, len(s.keys.keys))
+	for _, key := range s.keys.keys {
+		if !key.expiresAt.IsZero() && key.expiresAt.Before(time.Now()) {
+			continue
+		}
+		if jwk := key.jwk(); jwk != nil {
+			keys = append(keys, jwk)
+		}
+	}
+	response, _ := json.Marshal(map[string]any{"keys": keys})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(response)
+}
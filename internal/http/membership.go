@@ -0,0 +1,112 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/arkreddy21/eligos"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+// handleLeaveSpace removes the current user from a space. The last owner has to transfer
+// ownership or delete the space first, so no space is left without an owner. Direct
+// conversations can't be left.
+func (s *Server) handleLeaveSpace(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
+		return
+	}
+	role, err := s.SpaceService.GetRole(uid, spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("not a member of this space"))
+		return
+	}
+	space, err := s.SpaceService.GetSpace(spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not leave space"))
+		return
+	}
+	// a conversation always belongs to the same people, opening it again would find this one
+	if space.Kind == eligos.SpaceKindDm {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("conversations can't be left"))
+		return
+	}
+	err = s.SpaceService.LeaveSpace(uid, spaceid)
+	if errors.Is(err, eligos.ErrLastOwner) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("transfer ownership or delete the space before leaving"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not leave space"))
+		return
+	}
+	s.audit(spaceid, uid, &uid, eligos.AuditMemberLeft, map[string]any{"role": role})
+	payload, _ := json.Marshal(map[string]uuid.UUID{"spaceid": spaceid, "userid": uid})
+	s.hub.SendMessageToSpace(spaceid, "member_left", payload)
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
+}
+
+// handleTransferOwnership makes another member an owner of the space.
+// The current owner stays in the space as an admin.
+func (s *Server) handleTransferOwnership(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
+		return
+	}
+	var body struct {
+		UserId uuid.UUID
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, spaceid, eligos.PermissionTransferOwnership); !ok {
+		return
+	}
+	if body.UserId == uid {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("you already own this space"))
+		return
+	}
+	role, err := s.SpaceService.GetRole(body.UserId, spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user is not a member of this space"))
+		return
+	}
+	err = s.SpaceService.TransferOwnership(spaceid, uid, body.UserId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not transfer ownership"))
+		return
+	}
+	s.audit(spaceid, uid, &body.UserId, eligos.AuditOwnershipTransferred, map[string]any{"from": role})
+	payload, _ := json.Marshal(map[string]uuid.UUID{"spaceid": spaceid, "from": uid, "to": body.UserId})
+	s.hub.SendMessageToSpace(spaceid, "ownership_transferred", payload)
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
+}
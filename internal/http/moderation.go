@@ -0,0 +1,212 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/arkreddy21/eligos"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
+)
+
+// Longest a member can be muted for
+const maxMuteDuration = 30 * 24 * time.Hour
+
+// moderationRequest is the body of the kick, ban and mute endpoints
+type moderationRequest struct {
+	SpaceId uuid.UUID
+	UserId  uuid.UUID
+	Reason  string
+	// length of a mute
+	Minutes int
+}
+
+// moderationEvent is sent to the space and to the affected user when a moderator acts
+type moderationEvent struct {
+	SpaceId uuid.UUID  `json:"spaceid"`
+	UserId  uuid.UUID  `json:"userid"`
+	By      uuid.UUID  `json:"by"`
+	Reason  string     `json:"reason,omitempty"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+// decodeModeration reads a moderation request and checks that the current user may act on
+// its target. That needs the moderate permission and a role above the target's.
+func (s *Server) decodeModeration(w http.ResponseWriter, r *http.Request) (uuid.UUID, *moderationRequest, bool) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	var body moderationRequest
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return uid, nil, false
+	}
+	role, ok := s.authorizeSpace(w, uid, body.SpaceId, eligos.PermissionModerate)
+	if !ok {
+		return uid, nil, false
+	}
+	// users who aren't members have no role, so anyone who can moderate outranks them
+	target, _ := s.SpaceService.GetRole(body.UserId, body.SpaceId)
+	if !eligos.RoleOutranks(role, target) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("your role in this space does not allow this"))
+		return uid, nil, false
+	}
+	return uid, &body, true
+}
+
+func (s *Server) notifyModeration(proto string, event moderationEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	s.hub.SendMessageToSpace(event.SpaceId, proto, payload)
+	s.hub.SendMessageToUser(event.UserId, proto, payload)
+}
+
+// handleKick removes a member from a space. They can be added again later.
+func (s *Server) handleKick(w http.ResponseWriter, r *http.Request) {
+	uid, body, ok := s.decodeModeration(w, r)
+	if !ok {
+		return
+	}
+	if _, err := s.SpaceService.GetRole(body.UserId, body.SpaceId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user is not a member of this space"))
+		return
+	}
+	err := s.SpaceService.RemoveUserById(body.UserId, body.SpaceId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not kick user"))
+		return
+	}
+	s.audit(body.SpaceId, uid, &body.UserId, eligos.AuditMemberKicked, map[string]any{"reason": body.Reason})
+	s.notifyModeration("member_kicked", moderationEvent{SpaceId: body.SpaceId, UserId: body.UserId, By: uid, Reason: body.Reason})
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
+}
+
+// handleBan removes a user from a space and keeps them from being added or invited again
+func (s *Server) handleBan(w http.ResponseWriter, r *http.Request) {
+	uid, body, ok := s.decodeModeration(w, r)
+	if !ok {
+		return
+	}
+	if _, err := s.UserService.GetUserById(body.UserId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user not found"))
+		return
+	}
+	err := s.BanService.BanUser(&eligos.Ban{
+		SpaceId:  body.SpaceId,
+		UserId:   body.UserId,
+		BannedBy: &uid,
+		Reason:   body.Reason,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not ban user"))
+		return
+	}
+	s.audit(body.SpaceId, uid, &body.UserId, eligos.AuditMemberBanned, map[string]any{"reason": body.Reason})
+	s.notifyModeration("member_banned", moderationEvent{SpaceId: body.SpaceId, UserId: body.UserId, By: uid, Reason: body.Reason})
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
+}
+
+// handleUnban lifts a ban. The user isn't added back to the space.
+func (s *Server) handleUnban(w http.ResponseWriter, r *http.Request) {
+	uid, body, ok := s.decodeModeration(w, r)
+	if !ok {
+		return
+	}
+	err := s.BanService.UnbanUser(body.UserId, body.SpaceId)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user is not banned from this space"))
+		return
+	}
+	s.audit(body.SpaceId, uid, &body.UserId, eligos.AuditMemberUnbanned, nil)
+	s.notifyModeration("member_unbanned", moderationEvent{SpaceId: body.SpaceId, UserId: body.UserId, By: uid})
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
+}
+
+func (s *Server) handleGetBans(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(r.URL.Query().Get("spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("spaceid not provided"))
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, spaceid, eligos.PermissionModerate); !ok {
+		return
+	}
+	bans, err := s.BanService.GetBans(spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unable to get bans"))
+		return
+	}
+	response, _ := json.Marshal(bans)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// handleMute keeps a member from posting for a number of minutes. They can still read the space.
+func (s *Server) handleMute(w http.ResponseWriter, r *http.Request) {
+	uid, body, ok := s.decodeModeration(w, r)
+	if !ok {
+		return
+	}
+	duration := time.Duration(body.Minutes) * time.Minute
+	if body.Minutes <= 0 || duration > maxMuteDuration {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("mute must last between 1 minute and 30 days"))
+		return
+	}
+	until := time.Now().Add(duration)
+	err := s.SpaceService.SetMute(body.UserId, body.SpaceId, &until)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user is not a member of this space"))
+		return
+	}
+	s.audit(body.SpaceId, uid, &body.UserId, eligos.AuditMemberMuted, map[string]any{"reason": body.Reason, "until": until})
+	s.notifyModeration("member_muted", moderationEvent{SpaceId: body.SpaceId, UserId: body.UserId, By: uid, Reason: body.Reason, Until: &until})
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
+}
+
+func (s *Server) handleUnmute(w http.ResponseWriter, r *http.Request) {
+	uid, body, ok := s.decodeModeration(w, r)
+	if !ok {
+		return
+	}
+	err := s.SpaceService.SetMute(body.UserId, body.SpaceId, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user is not a member of this space"))
+		return
+	}
+	s.audit(body.SpaceId, uid, &body.UserId, eligos.AuditMemberUnmuted, nil)
+	s.notifyModeration("member_unmuted", moderationEvent{SpaceId: body.SpaceId, UserId: body.UserId, By: uid})
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
+}
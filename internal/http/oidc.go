@@ -0,0 +1,182 @@
+package http
+
+import (
+	"errors"
+	"github.com/arkreddy21/eligos"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Time a user has to complete a login at the identity provider
+	oidcStateTTL = 10 * time.Minute
+
+	// Cookie binding a pending login to the browser that started it
+	oidcStateCookie = "eligos_oidc_state"
+)
+
+func (s *Server) oidcRoutes(r chi.Router) {
+	r.Get("/login", s.handleOidcLogin)
+	r.Get("/callback", s.handleOidcCallback)
+}
+
+// handleOidcLogin redirects the browser to the identity provider
+func (s *Server) handleOidcLogin(w http.ResponseWriter, r *http.Request) {
+	if s.OidcProvider == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("single sign-on is not configured"))
+		return
+	}
+	state, err1 := randomToken(32)
+	nonce, err2 := randomToken(32)
+	verifier, err3 := randomToken(32)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not start login"))
+		return
+	}
+	redirect, err := s.OidcProvider.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		log.Println("unable to reach identity provider: ", err)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("could not reach identity provider"))
+		return
+	}
+	err = s.OidcService.CreateState(&eligos.OidcState{
+		StateHash:    hashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not start login"))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// handleOidcCallback completes a login when the identity provider redirects back.
+// The user is found by their linked identity or verified email, or created if they are new.
+// The browser is then sent to the web client with the usual tokens in the url fragment.
+func (s *Server) handleOidcCallback(w http.ResponseWriter, r *http.Request) {
+	if s.OidcProvider == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("single sign-on is not configured"))
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("login failed: " + query.Get("error")))
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || cookie.Value != query.Get("state") {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid login state"))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth/oidc", MaxAge: -1})
+	state, err := s.OidcService.ConsumeState(hashToken(cookie.Value))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("login expired"))
+		return
+	}
+	claims, err := s.OidcProvider.Exchange(query.Get("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		log.Println("oidc login failed: ", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("login failed"))
+		return
+	}
+	user, err := s.oidcUser(claims.Issuer, claims.Subject, claims.Email, claims.EmailVerified, claims.Name)
+	if err != nil {
+		log.Println("oidc login failed: ", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("login failed"))
+		return
+	}
+	token, refreshToken, err := s.createSession(r, user.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not login"))
+		return
+	}
+	fragment := url.Values{}
+	fragment.Set("token", token)
+	fragment.Set("refreshToken", refreshToken)
+	http.Redirect(w, r, s.baseUrl+"/oidc/callback#"+fragment.Encode(), http.StatusFound)
+}
+
+// oidcUser returns the user for an identity at the provider. An unknown identity is linked
+// to the user with the same email address, which the provider must have verified. An
+// account that never verified the address is taken over from whoever registered it.
+func (s *Server) oidcUser(issuer, subject, email string, emailVerified bool, name string) (*eligos.User, error) {
+	user, err := s.OidcService.GetUserByIdentity(issuer, subject)
+	if err == nil {
+		return user, nil
+	}
+	if email == "" || !emailVerified {
+		return nil, errors.New("identity provider did not return a verified email")
+	}
+	email = strings.ToLower(email)
+
+	user, err = s.UserService.GetUser(email)
+	if err != nil {
+		user = &eligos.User{
+			Name:          oidcDisplayName(name, email),
+			Email:         email,
+			EmailVerified: true,
+		}
+		// the user has no password and can only login through the provider until they reset it
+		err = s.UserService.CreateUser(user)
+		if err != nil {
+			return nil, err
+		}
+		err = s.OidcService.LinkIdentity(user.Id, issuer, subject)
+	} else if user.EmailVerified {
+		err = s.OidcService.LinkIdentity(user.Id, issuer, subject)
+	} else {
+		// anyone could have registered the unverified account to wait for the real owner
+		// of the address, so nothing they set up may keep working after it is claimed
+		var revoked []uuid.UUID
+		revoked, err = s.OidcService.ClaimUser(user.Id, issuer, subject)
+		for _, sessionid := range revoked {
+			s.hub.CloseSession(sessionid)
+		}
+		user.EmailVerified = true
+		user.Password, user.TotpSecret, user.TotpEnabled, user.RecoveryCodes = "", "", false, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.attachInvites(user.Id, user.Email)
+	return user, nil
+}
+
+// oidcDisplayName picks a name that fits in the users table
+func oidcDisplayName(name, email string) string {
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	if runes := []rune(name); len(runes) > 50 {
+		name = string(runes[:50])
+	}
+	return name
+}
@@ -0,0 +1,170 @@
+package http
+
+import (
+	"fmt"
+	"github.com/arkreddy21/eligos"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Lifetime of the link sent to reset a forgotten password
+const resetPasswordTTL = time.Hour
+
+// handleChangePassword changes the password of the current user after re-checking the old one.
+// Every other session of the user is logged out and their api tokens are deleted.
+func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	current := r.Context().Value("sessionId").(uuid.UUID)
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to parse form"))
+		return
+	}
+	oldPassword := r.Form.Get("oldPassword")
+	newPassword := r.Form.Get("newPassword")
+	if oldPassword == "" || newPassword == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("provide all input fields"))
+		return
+	}
+	user, err := s.UserService.GetUserById(uid)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user not found"))
+		return
+	}
+	if !s.checkPassword(user, oldPassword) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("password incorrect"))
+		return
+	}
+	if err := s.PasswordPolicy.Check(newPassword, user.Email); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = s.setPassword(user.Id, newPassword)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not change password"))
+		return
+	}
+	revoked, err := s.SessionService.RevokeOtherSessions(user.Id, current)
+	if err != nil {
+		log.Println("unable to revoke sessions: ", err)
+	}
+	// a token could have been created by whoever knew the old password
+	tokens, err := s.ApiTokenService.DeleteApiTokensByUser(user.Id)
+	if err != nil {
+		log.Println("unable to delete api tokens: ", err)
+	}
+	// connections opened with an api token are tracked under the token id
+	for _, sessionid := range append(revoked, tokens...) {
+		s.hub.CloseSession(sessionid)
+	}
+	w.Write([]byte("password changed"))
+}
+
+// handleForgotPassword mails a password reset link. The response is the same whether
+// or not the address is registered so it can't be used to discover accounts.
+func (s *Server) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to parse form"))
+		return
+	}
+	if !s.limitAccountEmail(w, r, eligos.AttemptResetPassword, r.Form.Get("email")) {
+		return
+	}
+	user, err := s.UserService.GetUser(r.Form.Get("email"))
+	if err == nil {
+		err = s.sendPasswordResetEmail(user)
+		if err != nil {
+			log.Println("unable to send password reset email: ", err)
+		}
+	}
+	w.Write([]byte("if the address is registered, a password reset email has been sent"))
+}
+
+func (s *Server) sendPasswordResetEmail(user *eligos.User) error {
+	token, err := s.createUserToken(user.Id, eligos.TokenPurposeResetPassword, user.Email, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+	link := s.baseUrl + "/reset-password?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your eligos account. Open the link below to choose a new password:\n\n%s\n\nThe link expires in 1 hour and can only be used once. If you did not ask for this you can ignore this email.\n", user.Name, link)
+	return s.Mailer.Send(user.Email, "Reset your password", body)
+}
+
+// handleResetPassword sets a new password using a token from a reset email.
+// All sessions of the user are logged out and their api tokens are deleted.
+func (s *Server) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to parse form"))
+		return
+	}
+	password := r.Form.Get("password")
+	if password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("provide all input fields"))
+		return
+	}
+	// checked before the token is used up so the user can pick another password
+	if err := s.PasswordPolicy.Check(password, ""); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	token, err := s.UserTokenService.ConsumeToken(hashToken(r.Form.Get("token")), eligos.TokenPurposeResetPassword)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid or expired token"))
+		return
+	}
+	user, err := s.UserService.GetUserById(token.UserId)
+	if err != nil || user.Email != token.Email {
+		// the link was mailed to an address the account no longer uses
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid or expired token"))
+		return
+	}
+	err = s.setPassword(user.Id, password)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not reset password"))
+		return
+	}
+	// any other reset link that was sent is no longer needed
+	err = s.UserTokenService.DeleteTokens(token.UserId, eligos.TokenPurposeResetPassword)
+	if err != nil {
+		log.Println("unable to delete reset tokens: ", err)
+	}
+	_, err = s.SessionService.RevokeAllSessions(token.UserId)
+	if err != nil {
+		log.Println("unable to revoke sessions: ", err)
+	}
+	_, err = s.ApiTokenService.DeleteApiTokensByUser(token.UserId)
+	if err != nil {
+		log.Println("unable to delete api tokens: ", err)
+	}
+	// every connection goes, including ones opened with an api token
+	s.hub.CloseUser(token.UserId)
+	w.Write([]byte("password reset successful"))
+}
+
+// setPassword hashes and stores a new password for the user
+func (s *Server) setPassword(userid uuid.UUID, password string) error {
+	hashedPassword, err := s.PasswordHasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	return s.UserService.UpdatePassword(userid, hashedPassword)
+}
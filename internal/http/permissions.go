@@ -0,0 +1,93 @@
+package http
+
+import (
+	"errors"
+	"github.com/arkreddy21/eligos"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+// findChannel returns the channel a request addresses. Without a channel id it is the default
+// channel of the space, which is what clients from before channels existed send.
+func (s *Server) findChannel(spaceid, channelid uuid.UUID) (*eligos.Channel, error) {
+	if channelid == uuid.Nil {
+		return s.ChannelService.GetDefaultChannel(spaceid)
+	}
+	channel, err := s.ChannelService.GetChannel(channelid)
+	if err != nil {
+		return nil, err
+	}
+	if spaceid != uuid.Nil && channel.SpaceId != spaceid {
+		return nil, errors.New("channel is not in this space")
+	}
+	return channel, nil
+}
+
+// canInChannel reports whether a user is a member of the channel's space and has a permission
+// in the channel. Muted members can't post.
+func (s *Server) canInChannel(userid uuid.UUID, channel *eligos.Channel, permission string) bool {
+	member, err := s.SpaceService.GetMember(userid, channel.SpaceId)
+	if err != nil {
+		return false
+	}
+	return channel.MemberCan(member, permission)
+}
+
+// authorizeSpace checks that a user has a permission in a space and writes a 403 if not.
+// It returns the role of the user in the space.
+func (s *Server) authorizeSpace(w http.ResponseWriter, userid, spaceid uuid.UUID, permission string) (string, bool) {
+	member, err := s.SpaceService.GetMember(userid, spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("not a member of this space"))
+		return "", false
+	}
+	if !member.Can(permission) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("your role in this space does not allow this"))
+		return member.Role, false
+	}
+	return member.Role, true
+}
+
+// authorizeChannel checks that a user has a permission in a channel and writes a 403 if not
+func (s *Server) authorizeChannel(w http.ResponseWriter, userid uuid.UUID, channel *eligos.Channel, permission string) bool {
+	if !s.canInChannel(userid, channel, permission) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("you don't have access to this channel"))
+		return false
+	}
+	return true
+}
+
+// checkNotArchived writes a 409 if the space is archived, archived spaces are read-only
+func (s *Server) checkNotArchived(w http.ResponseWriter, spaceid uuid.UUID) bool {
+	space, err := s.SpaceService.GetSpace(spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("space not found"))
+		return false
+	}
+	if space.ArchivedAt != nil {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("this space is archived"))
+		return false
+	}
+	return true
+}
+
+// checkNotBanned writes a 403 if the user is banned from the space
+func (s *Server) checkNotBanned(w http.ResponseWriter, userid, spaceid uuid.UUID) bool {
+	banned, err := s.BanService.IsBanned(userid, spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unable to check bans"))
+		return false
+	}
+	if banned {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("user is banned from this space"))
+		return false
+	}
+	return true
+}
@@ -5,12 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"github.com/arkreddy21/eligos"
+	"github.com/arkreddy21/eligos/internal/oidc"
+	"github.com/arkreddy21/eligos/internal/password"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,13 +25,41 @@ type Server struct {
 	//websocket hub
 	hub *Hub
 
-	jwtKey []byte
+	// keys access tokens are signed and verified with
+	keys *keySet
+
+	// iss claim of access tokens
+	tokenIssuer string
+
+	// public url of the web client, used to build links sent by email
+	baseUrl string
 
 	//database services
-	UserService    eligos.UserServiceI
-	SpaceService   eligos.SpaceServiceI
-	MessageService eligos.MessageServiceI
-	InviteService  eligos.InviteServiceI
+	UserService       eligos.UserServiceI
+	SpaceService      eligos.SpaceServiceI
+	ChannelService    eligos.ChannelServiceI
+	MessageService    eligos.MessageServiceI
+	InviteService     eligos.InviteServiceI
+	InviteLinkService eligos.InviteLinkServiceI
+	BanService        eligos.BanServiceI
+	AuditService      eligos.AuditServiceI
+	SessionService    eligos.SessionServiceI
+	UserTokenService  eligos.UserTokenServiceI
+	OidcService       eligos.OidcServiceI
+	ApiTokenService   eligos.ApiTokenServiceI
+
+	LoginAttemptService eligos.LoginAttemptServiceI
+
+	Mailer eligos.Mailer
+
+	PasswordHasher password.Hasher
+	PasswordPolicy *password.Policy
+
+	dummyHashOnce sync.Once
+	dummyHash     string
+
+	// single sign-on provider, nil if not configured
+	OidcProvider *oidc.Provider
 }
 
 func NewServer() *Server {
@@ -35,11 +67,37 @@ func NewServer() *Server {
 		router: chi.NewRouter(),
 	}
 
-	key, ok := os.LookupEnv("ELIGOSJWTKEY")
-	if !ok {
-		log.Fatal("ELIGOSJWTKEY env variable not set")
+	// asymmetric keys from ELIGOSJWTKEYDIR are preferred, so other services can verify
+	// tokens from the jwks endpoint. ELIGOSJWTKEY is a shared HS256 secret.
+	if dir, ok := os.LookupEnv("ELIGOSJWTKEYDIR"); ok {
+		grace := time.Hour
+		if g, ok := os.LookupEnv("ELIGOSJWTKEYGRACE"); ok {
+			var err error
+			grace, err = time.ParseDuration(g)
+			if err != nil {
+				log.Fatal("invalid ELIGOSJWTKEYGRACE: ", err)
+			}
+		}
+		keys, err := loadKeySet(dir, os.Getenv("ELIGOSJWTKID"), grace)
+		if err != nil {
+			log.Fatal("unable to load signing keys: ", err)
+		}
+		s.keys = keys
+	} else if key, ok := os.LookupEnv("ELIGOSJWTKEY"); ok {
+		s.keys = newHmacKeySet([]byte(key))
+	} else {
+		log.Fatal("ELIGOSJWTKEYDIR or ELIGOSJWTKEY env variable not set")
+	}
+
+	s.tokenIssuer = "eligos"
+	if issuer, ok := os.LookupEnv("ELIGOSJWTISSUER"); ok {
+		s.tokenIssuer = issuer
+	}
+
+	s.baseUrl = "http://localhost:5173"
+	if baseUrl, ok := os.LookupEnv("ELIGOSBASEURL"); ok {
+		s.baseUrl = strings.TrimSuffix(baseUrl, "/")
 	}
-	s.jwtKey = []byte(key)
 
 	s.router.Use(middleware.Logger)
 	s.router.Use(middleware.Recoverer)
@@ -52,15 +110,18 @@ func NewServer() *Server {
 		MaxAge:           300,
 	}))
 
+	s.router.Get("/.well-known/jwks.json", s.handleJwks)
 	s.router.Route("/api/auth", s.authRoutes)
 	s.router.Get("/api/ws", s.handleWs)
+	// lets people see where an invite link leads before they login
+	s.router.Get("/api/invite/link/{token}", s.handleInviteLinkPreview)
 
 	s.router.Group(func(r chi.Router) {
 		r.Use(s.validateJwt)
 		r.Get("/api/ping", func(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte("pong"))
 		})
-		r.Get("/api/user", s.handleUser)
+		r.Route("/api/user", s.userRoutes)
 		r.Route("/api/space", s.spaceRoutes)
 		r.Route("/api/invite", s.inviteRoutes)
 	})
@@ -0,0 +1,224 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/arkreddy21/eligos"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// Lifetime of an access token. Kept short since it is only checked against
+	// the session store and not reissued on revocation.
+	accessTokenTTL = 15 * time.Minute
+
+	// Lifetime of a refresh token. Every refresh rotates the token and extends the session.
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// createSession starts a new server-side session for the user and returns an access and refresh token pair.
+// The device label, ip and user agent of the request are recorded so the user can recognise the session later.
+func (s *Server) createSession(r *http.Request, userid uuid.UUID) (string, string, error) {
+	session := &eligos.Session{
+		UserId:      userid,
+		ExpiresAt:   time.Now().Add(refreshTokenTTL),
+		DeviceLabel: r.Form.Get("device"),
+		Ip:          clientIp(r),
+		UserAgent:   r.UserAgent(),
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	session.RefreshHash = hashToken(secret)
+	err = s.SessionService.CreateSession(session)
+	if err != nil {
+		return "", "", err
+	}
+	token, err := s.createToken(userid, session.Id)
+	if err != nil {
+		return "", "", err
+	}
+	return token, session.Id.String() + "." + secret, nil
+}
+
+// parseRefreshToken splits a refresh token into its session id and secret
+func parseRefreshToken(refreshToken string) (uuid.UUID, string, error) {
+	id, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || secret == "" {
+		return uuid.Nil, "", errors.New("malformed refresh token")
+	}
+	sessionid, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	return sessionid, secret, nil
+}
+
+// handleRefresh exchanges a refresh token for a new access token and a rotated refresh token
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to parse form"))
+		return
+	}
+	sessionid, secret, err := parseRefreshToken(r.Form.Get("refreshToken"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid refresh token"))
+		return
+	}
+	session, err := s.SessionService.GetSession(sessionid)
+	if err != nil || session.Revoked || session.ExpiresAt.Before(time.Now()) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("session expired"))
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(session.RefreshHash)) != 1 {
+		// the session id is readable in every access token, so a wrong secret alone proves nothing.
+		// Only a refresh token that was already rotated away means one was stolen, then the session ends.
+		if slices.Contains(session.PreviousHashes, hashToken(secret)) {
+			s.SessionService.RevokeSession(session.Id)
+			s.hub.CloseSession(session.Id)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("session expired"))
+		return
+	}
+
+	newSecret, err := randomToken(32)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not refresh session"))
+		return
+	}
+	rotated, err := s.SessionService.RotateSession(session.Id, session.RefreshHash, hashToken(newSecret), time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not refresh session"))
+		return
+	}
+	if !rotated {
+		// another refresh used the same token first, it is being replayed
+		s.SessionService.RevokeSession(session.Id)
+		s.hub.CloseSession(session.Id)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("session expired"))
+		return
+	}
+	token, err := s.createToken(session.UserId, session.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not refresh session"))
+		return
+	}
+	response, err := json.Marshal(map[string]any{
+		"message":      "success",
+		"token":        token,
+		"refreshToken": session.Id.String() + "." + newSecret,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// handleLogout revokes the session of the access token used to call it
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	sessionid := r.Context().Value("sessionId").(uuid.UUID)
+	err := s.SessionService.RevokeSession(sessionid)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not logout"))
+		return
+	}
+	s.hub.CloseSession(sessionid)
+	w.Write([]byte("logout successful"))
+}
+
+// handleGetSessions lists the active sessions of the current user
+func (s *Server) handleGetSessions(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	current := r.Context().Value("sessionId").(uuid.UUID)
+	sessions, err := s.SessionService.GetSessionsByUser(uid)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unable to get sessions"))
+		return
+	}
+	type sessionResponse struct {
+		eligos.Session
+		Current bool `json:"current"`
+	}
+	res := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		res = append(res, sessionResponse{Session: session, Current: session.Id == current})
+	}
+	response, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// handleRevokeSession revokes a single session of the current user and closes its websocket connection
+func (s *Server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	sessionid, err := uuid.Parse(chi.URLParam(r, "sessionid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid session id"))
+		return
+	}
+	session, err := s.SessionService.GetSession(sessionid)
+	if err != nil || session.UserId != uid {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("session not found"))
+		return
+	}
+	err = s.SessionService.RevokeSession(sessionid)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not revoke session"))
+		return
+	}
+	s.hub.CloseSession(sessionid)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRevokeOtherSessions revokes every session of the current user except the one making the request
+func (s *Server) handleRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	current := r.Context().Value("sessionId").(uuid.UUID)
+	revoked, err := s.SessionService.RevokeOtherSessions(uid, current)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not revoke sessions"))
+		return
+	}
+	for _, sessionid := range revoked {
+		s.hub.CloseSession(sessionid)
+	}
+	response, _ := json.Marshal(map[string]any{
+		"status":  "ok",
+		"revoked": len(revoked),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// clientIp returns the ip address of the remote end of the request
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -2,26 +2,67 @@ package http
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/arkreddy21/eligos"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
 )
 
 func (s *Server) spaceRoutes(r chi.Router) {
-	r.Post("/create", s.handleCreateSpace)
-	r.Post("/adduser", s.handleAddUserToSpace)
-	// returns all spaces that a user belongs to
-	r.Get("/spaces", s.handleGetSpaces)
-	// returns history of messages in a space
-	r.Get("/messages", s.handleGetMessages)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/create", s.handleCreateSpace)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/adduser", s.handleAddUserToSpace)
+	// opens a direct conversation, it is listed with the spaces
+	r.With(s.requireScope(eligos.ScopeMessagesWrite)).Post("/dm", s.handleOpenDm)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/role", s.handleSetRole)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/kick", s.handleKick)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/ban", s.handleBan)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/unban", s.handleUnban)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Get("/bans", s.handleGetBans)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/mute", s.handleMute)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/unmute", s.handleUnmute)
+	r.With(s.requireScope(eligos.ScopeMessagesRead)).Get("/{spaceid}/channels", s.handleGetChannels)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/{spaceid}/channels", s.handleCreateChannel)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/{spaceid}/channels/{channelid}", s.handleUpdateChannel)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Delete("/{spaceid}/channels/{channelid}", s.handleDeleteChannel)
+	// returns the audit log of a space, owners only
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Get("/{spaceid}/audit", s.handleGetAudit)
+	r.With(s.requireScope(eligos.ScopeMessagesRead)).Get("/{spaceid}", s.handleGetSpace)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/{spaceid}", s.handleUpdateSpace)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Delete("/{spaceid}", s.handleDeleteSpace)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/{spaceid}/archive", s.handleArchiveSpace)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/{spaceid}/unarchive", s.handleUnarchiveSpace)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/{spaceid}/leave", s.handleLeaveSpace)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/{spaceid}/transfer", s.handleTransferOwnership)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Get("/{spaceid}/invites", s.handleGetSpaceInvites)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Delete("/{spaceid}/invites/{inviteid}", s.handleRevokeInvite)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Get("/{spaceid}/invite-links", s.handleGetInviteLinks)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/{spaceid}/invite-links", s.handleCreateInviteLink)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Delete("/{spaceid}/invite-links/{linkid}", s.handleRevokeInviteLink)
+	// public spaces anyone can join
+	r.With(s.requireScope(eligos.ScopeMessagesRead)).Get("/directory", s.handleDirectory)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/{spaceid}/join", s.handleJoinSpace)
+	r.With(s.requireScope(eligos.ScopeSpacesManage)).Post("/{spaceid}/visibility", s.handleSetVisibility)
+	// returns all active spaces and direct conversations that a user belongs to, or the archived ones with ?archived=true
+	r.With(s.requireScope(eligos.ScopeMessagesRead)).Get("/spaces", s.handleGetSpaces)
+	// returns history of messages in a channel
+	r.With(s.requireScope(eligos.ScopeMessagesRead)).Get("/messages", s.handleGetMessages)
+	// returns the members of a space and their roles
+	r.With(s.requireScope(eligos.ScopeMessagesRead)).Get("/members", s.handleGetMembers)
 }
 
+// handleCreateSpace creates a space owned by the current user
 func (s *Server) handleCreateSpace(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
 	var body struct {
-		Name   string
-		Userid uuid.UUID
+		Name       string
+		Visibility string
 	}
 	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
 	dec := json.NewDecoder(r.Body)
@@ -38,13 +79,25 @@ func (s *Server) handleCreateSpace(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("please provide a name"))
 		return
 	}
-	err = s.SpaceService.CreateSpace(&eligos.Space{Name: body.Name}, body.Userid)
+	if body.Visibility != "" && !eligos.IsVisibility(body.Visibility) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("visibility must be private, public or unlisted"))
+		return
+	}
+	space := eligos.Space{Name: body.Name, Visibility: body.Visibility}
+	if err := validateSpace(&space); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = s.SpaceService.CreateSpace(&space, uid)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("could not create space"))
 		fmt.Println(err)
 		return
 	}
+	s.audit(space.Id, uid, &uid, eligos.AuditSpaceCreated, map[string]any{"name": space.Name, "visibility": space.Visibility, "role": eligos.RoleOwner})
 	response, err := json.Marshal(map[string]string{
 		"status": "ok",
 	})
@@ -52,6 +105,8 @@ func (s *Server) handleCreateSpace(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleAddUserToSpace(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
 	var body struct {
 		Email   string
 		SpaceId uuid.UUID
@@ -65,18 +120,28 @@ func (s *Server) handleAddUserToSpace(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
+	if _, ok := s.authorizeSpace(w, uid, body.SpaceId, eligos.PermissionAddMembers); !ok {
+		return
+	}
+	if !s.checkNotArchived(w, body.SpaceId) {
+		return
+	}
 	user, err := s.UserService.GetUser(body.Email)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("user not found"))
 		return
 	}
-	err = s.SpaceService.AddUserById(user.Id, body.SpaceId)
+	if !s.checkNotBanned(w, user.Id, body.SpaceId) {
+		return
+	}
+	err = s.SpaceService.AddUserById(user.Id, body.SpaceId, eligos.RoleMember)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("unable to add user to space"))
 		return
 	}
+	s.audit(body.SpaceId, uid, &user.Id, eligos.AuditMemberAdded, map[string]any{"role": eligos.RoleMember})
 	response, err := json.Marshal(map[string]string{
 		"status": "ok",
 	})
@@ -93,25 +158,49 @@ func (s *Server) handleGetSpaces(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("unable to parse body"))
 		return
 	}
-	response, _ := json.Marshal(*spaces)
+	// archived spaces are only listed when asked for
+	archived := r.URL.Query().Get("archived") == "true"
+	listed := make([]eligos.Space, 0, len(*spaces))
+	for _, space := range *spaces {
+		if (space.ArchivedAt != nil) == archived {
+			listed = append(listed, space)
+		}
+	}
+	response, _ := json.Marshal(listed)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(response)
 }
 
 func (s *Server) handleGetMessages(w http.ResponseWriter, r *http.Request) {
-	keys, ok := r.URL.Query()["spaceid"]
-	if !ok {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	// channelid, or only spaceid for the default channel of the space
+	var spaceid, channelid uuid.UUID
+	var err error
+	if v := r.URL.Query().Get("channelid"); v != "" {
+		channelid, err = uuid.Parse(v)
+	} else if v := r.URL.Query().Get("spaceid"); v != "" {
+		spaceid, err = uuid.Parse(v)
+	} else {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("spaceid not provided"))
 		return
 	}
-	spaceid, err := uuid.Parse(keys[0])
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("unable to parse body"))
 		return
 	}
-	messages, err := s.MessageService.GetMessages(spaceid)
+	channel, err := s.findChannel(spaceid, channelid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("channel not found"))
+		return
+	}
+	if !s.authorizeChannel(w, uid, channel, eligos.PermissionReadMessages) {
+		return
+	}
+	messages, err := s.MessageService.GetMessages(channel.Id)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("unable to get messages"))
@@ -121,3 +210,298 @@ func (s *Server) handleGetMessages(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(response)
 }
+
+func (s *Server) handleGetMembers(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(r.URL.Query().Get("spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("spaceid not provided"))
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, spaceid, eligos.PermissionReadMessages); !ok {
+		return
+	}
+	members, err := s.SpaceService.GetMembers(spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unable to get members"))
+		return
+	}
+	response, _ := json.Marshal(members)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// handleSetRole changes the role of a member. Users can only change the role of members
+// ranked below them, to a role below their own. Ownership can't be given away here.
+func (s *Server) handleSetRole(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	var body struct {
+		SpaceId uuid.UUID
+		UserId  uuid.UUID
+		Role    string
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !eligos.IsRole(body.Role) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unknown role"))
+		return
+	}
+	role, ok := s.authorizeSpace(w, uid, body.SpaceId, eligos.PermissionManageRoles)
+	if !ok {
+		return
+	}
+	current, err := s.SpaceService.GetRole(body.UserId, body.SpaceId)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user is not a member of this space"))
+		return
+	}
+	if !eligos.RoleOutranks(role, current) || !eligos.RoleOutranks(role, body.Role) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("your role in this space does not allow this"))
+		return
+	}
+	err = s.SpaceService.SetRole(body.UserId, body.SpaceId, body.Role)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not change role"))
+		return
+	}
+	s.audit(body.SpaceId, uid, &body.UserId, eligos.AuditRoleChanged, map[string]any{"from": current, "to": body.Role})
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
+}
+
+// handleGetSpace returns the details of a space the current user is a member of
+func (s *Server) handleGetSpace(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, spaceid, eligos.PermissionReadMessages); !ok {
+		return
+	}
+	space, err := s.SpaceService.GetSpace(spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("space not found"))
+		return
+	}
+	response, _ := json.Marshal(space)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// handleUpdateSpace changes the name, description, topic or icon of a space. Fields left out
+// are kept. Connected members are sent the updated space.
+func (s *Server) handleUpdateSpace(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
+		return
+	}
+	var body struct {
+		Name        *string
+		Description *string
+		Topic       *string
+		Icon        *string
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, spaceid, eligos.PermissionManageSpace); !ok {
+		return
+	}
+	space, err := s.SpaceService.GetSpace(spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("space not found"))
+		return
+	}
+	if space.ArchivedAt != nil {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("this space is archived"))
+		return
+	}
+	changes := map[string]any{}
+	if body.Name != nil {
+		space.Name = strings.TrimSpace(*body.Name)
+		changes["name"] = space.Name
+	}
+	if body.Description != nil {
+		space.Description = strings.TrimSpace(*body.Description)
+		changes["description"] = space.Description
+	}
+	if body.Topic != nil {
+		space.Topic = strings.TrimSpace(*body.Topic)
+		changes["topic"] = space.Topic
+	}
+	if body.Icon != nil {
+		space.Icon = strings.TrimSpace(*body.Icon)
+		changes["icon"] = space.Icon
+	}
+	if err := validateSpace(space); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = s.SpaceService.UpdateSpace(space)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not update space"))
+		return
+	}
+	s.audit(spaceid, uid, nil, eligos.AuditSpaceUpdated, changes)
+	response, _ := json.Marshal(space)
+	s.hub.SendMessageToSpace(spaceid, "space_updated", response)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func validateSpace(space *eligos.Space) error {
+	if space.Name == "" || utf8.RuneCountInString(space.Name) > 50 {
+		return errors.New("name must be 1-50 characters")
+	}
+	if utf8.RuneCountInString(space.Description) > 1000 {
+		return errors.New("description is too long")
+	}
+	if utf8.RuneCountInString(space.Topic) > 250 {
+		return errors.New("topic is too long")
+	}
+	if space.Icon != "" {
+		u, err := url.Parse(space.Icon)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || len(space.Icon) > 2048 {
+			return errors.New("icon must be an http or https url")
+		}
+	}
+	return nil
+}
+
+// handleDeleteSpace permanently deletes a space with everything in it. Only owners can do this.
+func (s *Server) handleDeleteSpace(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, spaceid, eligos.PermissionDeleteSpace); !ok {
+		return
+	}
+	space, err := s.SpaceService.GetSpace(spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("space not found"))
+		return
+	}
+	// members are looked up first, they are gone after the delete
+	users, err := s.SpaceService.GetUsersInSpace(spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not delete space"))
+		return
+	}
+	err = s.SpaceService.DeleteSpaceById(spaceid)
+	if err != nil {
+		log.Println("unable to delete space: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not delete space"))
+		return
+	}
+	s.audit(spaceid, uid, nil, eligos.AuditSpaceDeleted, map[string]any{"name": space.Name})
+	payload, _ := json.Marshal(map[string]uuid.UUID{"spaceid": spaceid})
+	for _, user := range *users {
+		s.hub.SendMessageToUser(user.Id, "space_deleted", payload)
+	}
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
+}
+
+// handleArchiveSpace makes a space read-only and hides it from the space list until it is unarchived
+func (s *Server) handleArchiveSpace(w http.ResponseWriter, r *http.Request) {
+	s.setArchived(w, r, true)
+}
+
+func (s *Server) handleUnarchiveSpace(w http.ResponseWriter, r *http.Request) {
+	s.setArchived(w, r, false)
+}
+
+func (s *Server) setArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	spaceid, err := uuid.Parse(chi.URLParam(r, "spaceid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid space id"))
+		return
+	}
+	if _, ok := s.authorizeSpace(w, uid, spaceid, eligos.PermissionArchiveSpace); !ok {
+		return
+	}
+	space, err := s.SpaceService.GetSpace(spaceid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("space not found"))
+		return
+	}
+	if (space.ArchivedAt != nil) == archived {
+		w.WriteHeader(http.StatusConflict)
+		if archived {
+			w.Write([]byte("space is already archived"))
+		} else {
+			w.Write([]byte("space is not archived"))
+		}
+		return
+	}
+	err = s.SpaceService.SetArchived(spaceid, archived)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not archive space"))
+		return
+	}
+	action := eligos.AuditSpaceUnarchived
+	if archived {
+		action = eligos.AuditSpaceArchived
+	}
+	s.audit(spaceid, uid, nil, action, nil)
+	space, err = s.SpaceService.GetSpace(spaceid)
+	if err == nil {
+		payload, _ := json.Marshal(space)
+		s.hub.SendMessageToSpace(spaceid, "space_updated", payload)
+	}
+	response, _ := json.Marshal(map[string]string{
+		"status": "ok",
+	})
+	w.Write(response)
+}
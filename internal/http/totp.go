@@ -0,0 +1,240 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/arkreddy21/eligos"
+	"github.com/arkreddy21/eligos/internal/totp"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// Time a user has to enter their totp code after entering a correct password
+	totpLoginTTL = 5 * time.Minute
+
+	// Number of recovery codes generated when two-factor authentication is enabled
+	recoveryCodeCount = 10
+
+	totpIssuer = "eligos"
+)
+
+// handleLoginTotp completes a login started at /login for users with two-factor authentication.
+// The code can be either a totp code or one of the user's recovery codes.
+func (s *Server) handleLoginTotp(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to parse form"))
+		return
+	}
+	code := r.Form.Get("code")
+	if code == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("provide all input fields"))
+		return
+	}
+	// the token is consumed even if the code is wrong, so every guess needs the password again
+	token, err := s.UserTokenService.ConsumeToken(hashToken(r.Form.Get("totpToken")), eligos.TokenPurposeLoginTotp)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("login expired"))
+		return
+	}
+	user, err := s.UserService.GetUserById(token.UserId)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("login expired"))
+		return
+	}
+	accountKey := eligos.AccountAttemptKey(user.Email)
+	if !s.checkLockout(w, accountKey) {
+		return
+	}
+	if !s.checkSecondFactor(user, code) {
+		s.recordFailedAttempt(accountKey, accountPolicy)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("code incorrect"))
+		return
+	}
+	s.LoginAttemptService.ResetAttempts(accountKey)
+	s.login(w, r, user.Id)
+}
+
+// handleTotpEnroll generates a new totp secret for the current user.
+// The current password is required. Two-factor authentication is only enabled once a code from it is confirmed.
+func (s *Server) handleTotpEnroll(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to parse form"))
+		return
+	}
+	user, err := s.UserService.GetUserById(uid)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user not found"))
+		return
+	}
+	if user.TotpEnabled {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("two-factor authentication is already enabled"))
+		return
+	}
+	// a stolen access token alone must not be enough to lock the owner out with a second factor
+	if !s.checkPassword(user, r.Form.Get("password")) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("password incorrect"))
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not enroll"))
+		return
+	}
+	err = s.UserService.SetTotp(user.Id, secret, false, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not enroll"))
+		return
+	}
+	response, _ := json.Marshal(map[string]string{
+		"secret": secret,
+		"uri":    totp.URI(totpIssuer, user.Email, secret),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// handleTotpConfirm enables two-factor authentication once the user proves their
+// authenticator app works. The recovery codes are only ever returned here.
+func (s *Server) handleTotpConfirm(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to parse form"))
+		return
+	}
+	user, err := s.UserService.GetUserById(uid)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user not found"))
+		return
+	}
+	if user.TotpEnabled || user.TotpSecret == "" {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("no pending two-factor enrollment"))
+		return
+	}
+	step, ok := totp.Validate(user.TotpSecret, r.Form.Get("code"), time.Now())
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("code incorrect"))
+		return
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not enable two-factor authentication"))
+		return
+	}
+	err = s.UserService.SetTotp(user.Id, user.TotpSecret, true, hashes)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not enable two-factor authentication"))
+		return
+	}
+	// the confirmation code can't be used to login
+	s.UserService.UseTotpStep(user.Id, step)
+	response, _ := json.Marshal(map[string]any{
+		"status":        "ok",
+		"recoveryCodes": codes,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// handleTotpDisable turns off two-factor authentication. It needs both the password and a code.
+func (s *Server) handleTotpDisable(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to parse form"))
+		return
+	}
+	user, err := s.UserService.GetUserById(uid)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user not found"))
+		return
+	}
+	if !user.TotpEnabled {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("two-factor authentication is not enabled"))
+		return
+	}
+	if !s.checkPassword(user, r.Form.Get("password")) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("password incorrect"))
+		return
+	}
+	if !s.checkSecondFactor(user, r.Form.Get("code")) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("code incorrect"))
+		return
+	}
+	err = s.UserService.SetTotp(user.Id, "", false, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not disable two-factor authentication"))
+		return
+	}
+	w.Write([]byte("two-factor authentication disabled"))
+}
+
+// checkSecondFactor accepts a totp code that hasn't been used yet or an unused recovery code
+func (s *Server) checkSecondFactor(user *eligos.User, code string) bool {
+	if step, ok := totp.Validate(user.TotpSecret, code, time.Now()); ok {
+		ok, err := s.UserService.UseTotpStep(user.Id, step)
+		if err != nil {
+			log.Println("unable to record totp step: ", err)
+		}
+		return ok
+	}
+	ok, err := s.UserService.UseRecoveryCode(user.Id, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		log.Println("unable to use recovery code: ", err)
+	}
+	return ok
+}
+
+// generateRecoveryCodes returns new recovery codes formatted for display, and their hashes for storage
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return nil, nil, err
+		}
+		// 16 base32 characters, 80 bits of entropy
+		code := secret[:16]
+		codes = append(codes, code[:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode removes the formatting a user may have typed with a recovery code
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/arkreddy21/eligos"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+func (s *Server) userRoutes(r chi.Router) {
+	r.Get("/", s.handleUser)
+
+	// account management needs a login session, api tokens can't be used
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireSession)
+		r.Get("/sessions", s.handleGetSessions)
+		r.Delete("/sessions/{sessionid}", s.handleRevokeSession)
+		r.Post("/sessions/revoke-others", s.handleRevokeOtherSessions)
+		r.Post("/profile", s.handleUpdateProfile)
+		r.Post("/email", s.handleChangeEmail)
+		r.Post("/delete", s.handleDeleteAccount)
+		r.Get("/export", s.handleExport)
+		r.Post("/password", s.handleChangePassword)
+		r.Post("/totp/enroll", s.handleTotpEnroll)
+		r.Post("/totp/confirm", s.handleTotpConfirm)
+		r.Post("/totp/disable", s.handleTotpDisable)
+		r.Get("/tokens", s.handleGetApiTokens)
+		r.Post("/tokens", s.handleCreateApiToken)
+		r.Delete("/tokens/{tokenid}", s.handleDeleteApiToken)
+	})
+}
+
+func (s *Server) handleUser(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	user, err := s.UserService.GetUserById(uid)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user not found"))
+		return
+	}
+	jsonResp, _ := json.Marshal(user)
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(jsonResp)
+}
+
+func (s *Server) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to parse form"))
+		return
+	}
+	name := strings.TrimSpace(r.Form.Get("name"))
+	if name == "" || utf8.RuneCountInString(name) > 50 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("please provide a name of at most 50 characters"))
+		return
+	}
+	err = s.UserService.UpdateName(uid, name)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not update profile"))
+		return
+	}
+	s.handleUser(w, r)
+}
+
+// handleChangeEmail starts an email change by mailing a confirmation link to the new address
+func (s *Server) handleChangeEmail(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to parse form"))
+		return
+	}
+	email, err := parseEmail(r.Form.Get("email"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid email address"))
+		return
+	}
+	user, err := s.UserService.GetUserById(uid)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user not found"))
+		return
+	}
+	if !s.checkPassword(user, r.Form.Get("password")) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("password incorrect"))
+		return
+	}
+	if _, err := s.UserService.GetUser(email); err == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("email is already in use"))
+		return
+	}
+	err = s.sendChangeEmail(user, email)
+	if err != nil {
+		log.Println("unable to send change email: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not send confirmation email"))
+		return
+	}
+	w.Write([]byte("check your new email address to confirm the change"))
+}
+
+// handleDeleteAccount deletes the current user after re-checking their password.
+// Their messages stay in their spaces without an author.
+func (s *Server) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("userId").(string)
+	uid, _ := uuid.Parse(userId)
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to parse form"))
+		return
+	}
+	user, err := s.UserService.GetUserById(uid)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user not found"))
+		return
+	}
+	if !s.checkPassword(user, r.Form.Get("password")) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("password incorrect"))
+		return
+	}
+	// spaces can't be left without an owner
+	owned, err := s.SpaceService.GetSoleOwnedSpaces(user.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not delete account"))
+		return
+	}
+	if len(owned) > 0 {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("transfer ownership of or delete the spaces you own first"))
+		return
+	}
+	err = s.UserService.DeleteUser(user.Id)
+	if errors.Is(err, eligos.ErrLastOwner) {
+		// another owner left after the check above
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("transfer ownership of or delete the spaces you own first"))
+		return
+	}
+	if err != nil {
+		log.Println("unable to delete user: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("could not delete account"))
+		return
+	}
+	s.hub.CloseUser(user.Id)
+	w.Write([]byte("account deleted"))
+}
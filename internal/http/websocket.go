@@ -1,11 +1,12 @@
 package http
 
 import (
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/arkreddy21/eligos"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
+	"strings"
 )
 
 var upgrader = websocket.Upgrader{
@@ -24,20 +25,35 @@ func (s *Server) handleWs(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("token not provided"))
 		return
 	}
-	token, err := jwt.ParseWithClaims(keys[0], &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return s.jwtKey, nil
-	})
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("user unauthorized"))
-		return
-	}
-	claims := token.Claims.(*jwt.RegisteredClaims)
-	userid, err := uuid.Parse(claims.Issuer)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	client := Client{hub: s.hub, send: make(chan []byte, 256)}
+	if strings.HasPrefix(keys[0], apiTokenPrefix) {
+		apiToken, err := s.authenticateApiToken(keys[0])
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("user unauthorized"))
+			return
+		}
+		if !hasScope(apiToken.Scopes, eligos.ScopeMessagesRead) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("api token is missing the " + eligos.ScopeMessagesRead + " scope"))
+			return
+		}
+		// deleting the token closes the connection like a revoked session
+		client.id, client.session, client.scopes = apiToken.UserId, apiToken.Id, apiToken.Scopes
+	} else {
+		claims, err := s.authenticate(keys[0])
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("user unauthorized"))
+			return
+		}
+		userid, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		client.id, client.session = userid, claims.SessionId
 	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -46,7 +62,7 @@ func (s *Server) handleWs(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	client := Client{hub: s.hub, conn: conn, id: userid, send: make(chan []byte, 256)}
+	client.conn = conn
 	s.hub.register <- &client
 	go client.writePump()
 	go client.readPump()
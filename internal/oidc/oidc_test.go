@@ -0,0 +1,256 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientId    = "eligos"
+	testRedirectUrl = "https://eligos.test/api/auth/oidc/callback"
+	testKid         = "test-key"
+)
+
+// mockIdP is a local identity provider that implements discovery, the jwks
+// endpoint and the token endpoint of the authorization code flow with PKCE
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	// key published in the jwks
+	key *rsa.PrivateKey
+	// key ID tokens are signed with, normally the published key
+	signingKey *rsa.PrivateKey
+	// issuer put in the discovery document, the server url if empty
+	discoveryIssuer string
+	// changes the claims of the next ID token
+	editClaims func(claims jwt.MapClaims)
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// authorization is what the provider remembers about a code it handed out
+type authorization struct {
+	challenge string
+	nonce     string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{t: t, key: key, signingKey: key, codes: make(map[string]authorization)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.handleDiscovery)
+	mux.HandleFunc("/jwks", idp.handleJwks)
+	mux.HandleFunc("/token", idp.handleToken)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) provider() *Provider {
+	return NewProvider(Config{Issuer: idp.server.URL, ClientId: testClientId, RedirectUrl: testRedirectUrl})
+}
+
+// authorize plays the part of the login page: it checks the request and returns the code
+// the provider would redirect back with
+func (idp *mockIdP) authorize(authUrl, state string) string {
+	u, err := url.Parse(authUrl)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	q := u.Query()
+	for param, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientId,
+		"redirect_uri":          testRedirectUrl,
+		"state":                 state,
+		"code_challenge_method": "S256",
+	} {
+		if got := q.Get(param); got != want {
+			idp.t.Fatalf("authorization request %s = %q, want %q", param, got, want)
+		}
+	}
+	if !strings.Contains(q.Get("scope"), "openid") {
+		idp.t.Fatalf("authorization request scope %q is missing openid", q.Get("scope"))
+	}
+	code := base64.RawURLEncoding.EncodeToString([]byte(q.Get("nonce") + state))
+	idp.mu.Lock()
+	idp.codes[code] = authorization{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	idp.mu.Unlock()
+	return code
+}
+
+func (idp *mockIdP) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	issuer := idp.discoveryIssuer
+	if issuer == "" {
+		issuer = idp.server.URL
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 issuer,
+		"authorization_endpoint": idp.server.URL + "/authorize",
+		"token_endpoint":         idp.server.URL + "/token",
+		"jwks_uri":               idp.server.URL + "/jwks",
+	})
+}
+
+func (idp *mockIdP) handleJwks(w http.ResponseWriter, r *http.Request) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": testKid,
+		"use": "sig",
+		"n":   b64(idp.key.N.Bytes()),
+		"e":   b64(big.NewInt(int64(idp.key.E)).Bytes()),
+	}}})
+}
+
+func (idp *mockIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		tokenError("invalid_request")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("client_id") != testClientId ||
+		r.PostForm.Get("redirect_uri") != testRedirectUrl {
+		tokenError("invalid_request")
+		return
+	}
+	idp.mu.Lock()
+	auth, ok := idp.codes[r.PostForm.Get("code")]
+	// codes can only be used once
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+	if !ok || CodeChallenge(r.PostForm.Get("code_verifier")) != auth.challenge {
+		tokenError("invalid_grant")
+		return
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            idp.server.URL,
+		"sub":            "user-1",
+		"aud":            testClientId,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          auth.nonce,
+		"email":          "user@example.com",
+		"email_verified": true,
+		"name":           "Test User",
+	}
+	if idp.editClaims != nil {
+		idp.editClaims(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKid
+	idToken, err := token.SignedString(idp.signingKey)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+// login runs the whole flow against the mock provider
+func login(t *testing.T, idp *mockIdP, verifier, nonce string) (*Claims, error) {
+	p := idp.provider()
+	authUrl, err := p.AuthCodeURL("state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := idp.authorize(authUrl, "state-1")
+	return p.Exchange(code, verifier, nonce)
+}
+
+func TestExchange(t *testing.T) {
+	idp := newMockIdP(t)
+	claims, err := login(t, idp, "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Claims{Issuer: idp.server.URL, Subject: "user-1", Email: "user@example.com", EmailVerified: true, Name: "Test User"}
+	if *claims != want {
+		t.Errorf("claims = %+v, want %+v", *claims, want)
+	}
+}
+
+func TestExchangeRejected(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		setup    func(idp *mockIdP)
+		verifier string
+		nonce    string
+	}{
+		{name: "wrong code verifier", verifier: "verifier-2", nonce: "nonce-1"},
+		{name: "wrong nonce", verifier: "verifier-1", nonce: "nonce-2"},
+		{name: "missing nonce", verifier: "verifier-1", nonce: ""},
+		{name: "wrong issuer", verifier: "verifier-1", nonce: "nonce-1", setup: func(idp *mockIdP) {
+			idp.editClaims = func(c jwt.MapClaims) { c["iss"] = "https://evil.test" }
+		}},
+		{name: "wrong audience", verifier: "verifier-1", nonce: "nonce-1", setup: func(idp *mockIdP) {
+			idp.editClaims = func(c jwt.MapClaims) { c["aud"] = "someone-else" }
+		}},
+		{name: "expired", verifier: "verifier-1", nonce: "nonce-1", setup: func(idp *mockIdP) {
+			idp.editClaims = func(c jwt.MapClaims) {
+				c["iat"] = time.Now().Add(-time.Hour).Unix()
+				c["exp"] = time.Now().Add(-30 * time.Minute).Unix()
+			}
+		}},
+		{name: "no expiry", verifier: "verifier-1", nonce: "nonce-1", setup: func(idp *mockIdP) {
+			idp.editClaims = func(c jwt.MapClaims) { delete(c, "exp") }
+		}},
+		{name: "no subject", verifier: "verifier-1", nonce: "nonce-1", setup: func(idp *mockIdP) {
+			idp.editClaims = func(c jwt.MapClaims) { delete(c, "sub") }
+		}},
+		{name: "signed with another key", verifier: "verifier-1", nonce: "nonce-1", setup: func(idp *mockIdP) {
+			idp.signingKey = otherKey
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			idp := newMockIdP(t)
+			if test.setup != nil {
+				test.setup(idp)
+			}
+			claims, err := login(t, idp, test.verifier, test.nonce)
+			if err == nil {
+				t.Fatalf("login succeeded with claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.discoveryIssuer = "https://evil.test"
+	_, err := idp.provider().AuthCodeURL("state-1", "nonce-1", "verifier-1")
+	if err == nil {
+		t.Fatal("discovery document of another issuer was accepted")
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// example from RFC 7636 appendix B
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("CodeChallenge = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,31 @@
+// Package password hashes and verifies user passwords.
+//
+// Hashes are stored as self describing strings: argon2id hashes use the PHC string
+// format and bcrypt hashes use their usual modular crypt format. A hash can always
+// be verified regardless of the configured Hasher, which lets passwords be moved to
+// a new algorithm or cost when their users next login.
+package password
+
+import (
+	"strings"
+)
+
+// Hasher creates hashes of new passwords
+type Hasher interface {
+	Hash(password string) (string, error)
+	// NeedsRehash reports whether an encoded hash was made with a different
+	// algorithm or parameters than this hasher would use
+	NeedsRehash(encoded string) bool
+}
+
+// Verify checks a password against an encoded hash of any supported algorithm
+func Verify(password, encoded string) bool {
+	switch {
+	case strings.HasPrefix(encoded, argon2idPrefix):
+		return verifyArgon2id(password, encoded)
+	case isBcrypt(encoded):
+		return verifyBcrypt(password, encoded)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,60 @@
+package password
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Policy decides which new passwords are acceptable
+type Policy struct {
+	MinLength int
+	// MaxLength is in bytes, since that's what hashing cost depends on
+	MaxLength int
+	// lowercased passwords that are too common to allow
+	banned map[string]bool
+}
+
+// DefaultPolicy follows NIST SP 800-63B: a minimum length and no composition rules
+var DefaultPolicy = Policy{MinLength: 8, MaxLength: 72}
+
+// LoadBannedPasswords reads a list of banned passwords from a file with one
+// password per line. Empty lines and lines starting with # are skipped.
+func (p *Policy) LoadBannedPasswords(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if p.banned == nil {
+		p.banned = make(map[string]bool)
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.banned[strings.ToLower(line)] = true
+	}
+	return scanner.Err()
+}
+
+// Check returns an error describing why a password isn't allowed, nil if it is.
+// The email of the user is also rejected as a password.
+func (p *Policy) Check(password, email string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		return fmt.Errorf("password must be at most %d bytes", p.MaxLength)
+	}
+	lower := strings.ToLower(password)
+	if p.banned[lower] || lower == strings.ToLower(email) {
+		return errors.New("password is too common, choose another one")
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"github.com/arkreddy21/eligos"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type ApiTokenService struct {
+	db *DB
+}
+
+func NewApiTokenService(db *DB) *ApiTokenService {
+	return &ApiTokenService{db: db}
+}
+
+const apiTokenColumns = "id, userid, name, hash, scopes, createdat, lastusedat, expiresat"
+
+func scanApiToken(row pgx.Row) (eligos.ApiToken, error) {
+	var token eligos.ApiToken
+	err := row.Scan(&token.Id, &token.UserId, &token.Name, &token.Hash, &token.Scopes, &token.CreatedAt, &token.LastUsedAt, &token.ExpiresAt)
+	return token, err
+}
+
+func (s *ApiTokenService) CreateApiToken(token *eligos.ApiToken) error {
+	token.Id = uuid.New()
+	token.CreatedAt = time.Now()
+	_, err := s.db.dbpool.Exec(context.Background(), "INSERT INTO apitokens ("+apiTokenColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		token.Id, token.UserId, token.Name, token.Hash, token.Scopes, token.CreatedAt, token.LastUsedAt, token.ExpiresAt)
+	return err
+}
+
+func (s *ApiTokenService) GetApiTokenByHash(hash string) (*eligos.ApiToken, error) {
+	token, err := scanApiToken(s.db.dbpool.QueryRow(context.Background(), "SELECT "+apiTokenColumns+" FROM apitokens WHERE hash=$1", hash))
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *ApiTokenService) GetApiTokensByUser(userid uuid.UUID) ([]eligos.ApiToken, error) {
+	rows, err := s.db.dbpool.Query(context.Background(), "SELECT "+apiTokenColumns+" FROM apitokens WHERE userid=$1 ORDER BY createdat", userid)
+	defer rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (eligos.ApiToken, error) {
+		return scanApiToken(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// DeleteApiToken deletes a token owned by userid
+func (s *ApiTokenService) DeleteApiToken(id, userid uuid.UUID) error {
+	tag, err := s.db.dbpool.Exec(context.Background(), "DELETE FROM apitokens WHERE id=$1 AND userid=$2", id, userid)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("api token not found")
+	}
+	return nil
+}
+
+func (s *ApiTokenService) DeleteApiTokensByUser(userid uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := s.db.dbpool.Query(context.Background(), "DELETE FROM apitokens WHERE userid=$1 RETURNING id", userid)
+	defer rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
+
+// TouchApiToken records that a token has just been used.
+// Writes are skipped if the token was already used within the last minute.
+func (s *ApiTokenService) TouchApiToken(id uuid.UUID) error {
+	now := time.Now()
+	_, err := s.db.dbpool.Exec(context.Background(), "UPDATE apitokens SET lastusedat=$2 WHERE id=$1 AND (lastusedat IS NULL OR lastusedat < $3)", id, now, now.Add(-time.Minute))
+	return err
+}
@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/arkreddy21/eligos"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type AuditService struct {
+	db *DB
+}
+
+func NewAuditService(db *DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+const auditColumns = "id, spaceid, actorid, targetid, action, metadata, createdat"
+
+func scanAuditEntry(row pgx.Row) (eligos.AuditEntry, error) {
+	var entry eligos.AuditEntry
+	err := row.Scan(&entry.Id, &entry.SpaceId, &entry.ActorId, &entry.TargetId, &entry.Action, &entry.Metadata, &entry.CreatedAt)
+	return entry, err
+}
+
+func (s *AuditService) CreateAuditEntry(entry *eligos.AuditEntry) error {
+	entry.CreatedAt = time.Now()
+	if entry.Metadata == nil {
+		entry.Metadata = map[string]any{}
+	}
+	return s.db.dbpool.QueryRow(context.Background(), "INSERT INTO auditlog (spaceid, actorid, targetid, action, metadata, createdat) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		entry.SpaceId, entry.ActorId, entry.TargetId, entry.Action, entry.Metadata, entry.CreatedAt).Scan(&entry.Id)
+}
+
+func (s *AuditService) GetAuditEntries(filter eligos.AuditFilter) ([]eligos.AuditEntry, error) {
+	query := "SELECT " + auditColumns + " FROM auditlog WHERE spaceid=$1"
+	args := []any{filter.SpaceId}
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		query += fmt.Sprintf(" AND %s $%d", condition, len(args))
+	}
+	if filter.ActorId != nil {
+		where("actorid =", *filter.ActorId)
+	}
+	if filter.TargetId != nil {
+		where("targetid =", *filter.TargetId)
+	}
+	if filter.Action != "" {
+		where("action =", filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		where("createdat >=", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		where("createdat <", filter.Until)
+	}
+	if filter.Before > 0 {
+		where("id <", filter.Before)
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := s.db.dbpool.Query(context.Background(), query, args...)
+	defer rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (eligos.AuditEntry, error) {
+		return scanAuditEntry(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
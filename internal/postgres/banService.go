@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"github.com/arkreddy21/eligos"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type BanService struct {
+	db *DB
+}
+
+func NewBanService(db *DB) *BanService {
+	return &BanService{db: db}
+}
+
+// BanUser removes the user from the space and records the ban in one transaction
+func (s *BanService) BanUser(ban *eligos.Ban) error {
+	ctx := context.Background()
+	ban.CreatedAt = time.Now()
+	tx, err := s.db.dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	_, err = tx.Exec(ctx, "DELETE FROM userspaces WHERE userid=$1 AND spaceid=$2", ban.UserId, ban.SpaceId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO bans (spaceid, userid, bannedby, reason, createdat) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (spaceid, userid) DO UPDATE SET bannedby=$3, reason=$4, createdat=$5`,
+		ban.SpaceId, ban.UserId, ban.BannedBy, ban.Reason, ban.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *BanService) UnbanUser(userid, spaceid uuid.UUID) error {
+	tag, err := s.db.dbpool.Exec(context.Background(), "DELETE FROM bans WHERE userid=$1 AND spaceid=$2", userid, spaceid)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("user is not banned from the space")
+	}
+	return nil
+}
+
+func (s *BanService) IsBanned(userid, spaceid uuid.UUID) (bool, error) {
+	var banned bool
+	err := s.db.dbpool.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM bans WHERE userid=$1 AND spaceid=$2)", userid, spaceid).Scan(&banned)
+	if err != nil {
+		return false, err
+	}
+	return banned, nil
+}
+
+func (s *BanService) GetBans(spaceid uuid.UUID) ([]eligos.Ban, error) {
+	rows, err := s.db.dbpool.Query(context.Background(), "SELECT b.spaceid, b.userid, u.name, b.bannedby, b.reason, b.createdat FROM bans b JOIN users u ON u.id=b.userid WHERE b.spaceid=$1 ORDER BY b.createdat DESC", spaceid)
+	defer rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	bans, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (eligos.Ban, error) {
+		var ban eligos.Ban
+		err := row.Scan(&ban.SpaceId, &ban.UserId, &ban.Name, &ban.BannedBy, &ban.Reason, &ban.CreatedAt)
+		return ban, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	"github.com/arkreddy21/eligos"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type ChannelService struct {
+	db *DB
+}
+
+func NewChannelService(db *DB) *ChannelService {
+	return &ChannelService{db: db}
+}
+
+const channelColumns = "id, spaceid, name, topic, isdefault, overrides, createdat"
+
+func scanChannel(row pgx.Row) (eligos.Channel, error) {
+	var channel eligos.Channel
+	err := row.Scan(&channel.Id, &channel.SpaceId, &channel.Name, &channel.Topic, &channel.IsDefault, &channel.Overrides, &channel.CreatedAt)
+	return channel, err
+}
+
+func (s *ChannelService) CreateChannel(channel *eligos.Channel) error {
+	channel.Id = uuid.New()
+	channel.CreatedAt = time.Now()
+	if channel.Overrides == nil {
+		channel.Overrides = map[string]map[string]bool{}
+	}
+	_, err := s.db.dbpool.Exec(context.Background(), "INSERT INTO channels ("+channelColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		channel.Id, channel.SpaceId, channel.Name, channel.Topic, channel.IsDefault, channel.Overrides, channel.CreatedAt)
+	return err
+}
+
+func (s *ChannelService) GetChannel(id uuid.UUID) (*eligos.Channel, error) {
+	channel, err := scanChannel(s.db.dbpool.QueryRow(context.Background(), "SELECT "+channelColumns+" FROM channels WHERE id=$1", id))
+	if err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+func (s *ChannelService) GetChannels(spaceid uuid.UUID) ([]eligos.Channel, error) {
+	rows, err := s.db.dbpool.Query(context.Background(), "SELECT "+channelColumns+" FROM channels WHERE spaceid=$1 ORDER BY isdefault DESC, name", spaceid)
+	defer rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	channels, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (eligos.Channel, error) {
+		return scanChannel(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+func (s *ChannelService) GetDefaultChannel(spaceid uuid.UUID) (*eligos.Channel, error) {
+	channel, err := scanChannel(s.db.dbpool.QueryRow(context.Background(), "SELECT "+channelColumns+" FROM channels WHERE spaceid=$1 AND isdefault", spaceid))
+	if err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+func (s *ChannelService) UpdateChannel(channel *eligos.Channel) error {
+	if channel.Overrides == nil {
+		channel.Overrides = map[string]map[string]bool{}
+	}
+	_, err := s.db.dbpool.Exec(context.Background(), "UPDATE channels SET name=$2, topic=$3, overrides=$4 WHERE id=$1",
+		channel.Id, channel.Name, channel.Topic, channel.Overrides)
+	return err
+}
+
+func (s *ChannelService) DeleteChannel(id uuid.UUID) error {
+	ctx := context.Background()
+	tx, err := s.db.dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	_, err = tx.Exec(ctx, "DELETE FROM messages WHERE channelid=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "DELETE FROM channels WHERE id=$1", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"github.com/arkreddy21/eligos"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type InviteLinkService struct {
+	db *DB
+}
+
+func NewInviteLinkService(db *DB) *InviteLinkService {
+	return &InviteLinkService{db: db}
+}
+
+const inviteLinkColumns = "id, spaceid, hash, role, maxuses, uses, expiresat, createdby, createdat"
+
+func scanInviteLink(row pgx.Row) (eligos.InviteLink, error) {
+	var link eligos.InviteLink
+	err := row.Scan(&link.Id, &link.SpaceId, &link.Hash, &link.Role, &link.MaxUses, &link.Uses, &link.ExpiresAt, &link.CreatedBy, &link.CreatedAt)
+	return link, err
+}
+
+func (s *InviteLinkService) CreateInviteLink(link *eligos.InviteLink) error {
+	link.Id = uuid.New()
+	link.CreatedAt = time.Now()
+	_, err := s.db.dbpool.Exec(context.Background(), "INSERT INTO invitelinks ("+inviteLinkColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		link.Id, link.SpaceId, link.Hash, link.Role, link.MaxUses, link.Uses, link.ExpiresAt, link.CreatedBy, link.CreatedAt)
+	return err
+}
+
+func (s *InviteLinkService) GetInviteLinkByHash(hash string) (*eligos.InviteLink, error) {
+	link, err := scanInviteLink(s.db.dbpool.QueryRow(context.Background(), "SELECT "+inviteLinkColumns+" FROM invitelinks WHERE hash=$1", hash))
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (s *InviteLinkService) GetInviteLinks(spaceid uuid.UUID) ([]eligos.InviteLink, error) {
+	rows, err := s.db.dbpool.Query(context.Background(), "SELECT "+inviteLinkColumns+" FROM invitelinks WHERE spaceid=$1 ORDER BY createdat DESC", spaceid)
+	defer rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	links, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (eligos.InviteLink, error) {
+		return scanInviteLink(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (s *InviteLinkService) DeleteInviteLink(id, spaceid uuid.UUID) error {
+	tag, err := s.db.dbpool.Exec(context.Background(), "DELETE FROM invitelinks WHERE id=$1 AND spaceid=$2", id, spaceid)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("invite link not found")
+	}
+	return nil
+}
+
+func (s *InviteLinkService) RedeemInviteLink(id, userid uuid.UUID) error {
+	ctx := context.Background()
+	tx, err := s.db.dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	// the row lock taken here keeps concurrent redemptions from going over maxuses
+	var spaceid uuid.UUID
+	var role string
+	err = tx.QueryRow(ctx, `UPDATE invitelinks SET uses = uses + 1
+		WHERE id=$1 AND (maxuses IS NULL OR uses < maxuses) AND (expiresat IS NULL OR expiresat > now())
+		RETURNING spaceid, role`, id).Scan(&spaceid, &role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errors.New("invite link has expired or been used up")
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO userspaces (userid, spaceid, role) VALUES ($1, $2, $3)", userid, spaceid, role)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -2,9 +2,11 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"github.com/arkreddy21/eligos"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"time"
 )
 
 type InviteService struct {
@@ -15,9 +17,27 @@ func NewInviteService(db *DB) *InviteService {
 	return &InviteService{db: db}
 }
 
+const inviteColumns = "id, spaceid, spaceName, email, userid, invitedby, createdat, expiresat"
+
+func scanInvite(row pgx.Row) (eligos.Invite, error) {
+	var invite eligos.Invite
+	err := row.Scan(&invite.Id, &invite.SpaceId, &invite.SpaceName, &invite.Email, &invite.UserId, &invite.InvitedBy, &invite.CreatedAt, &invite.ExpiresAt)
+	return invite, err
+}
+
 func (s *InviteService) CreateInvite(invite *eligos.Invite) error {
 	invite.Id = uuid.New()
-	_, err := s.db.dbpool.Exec(context.Background(), "INSERT INTO invites VALUES ($1, $2, $3, $4)", invite.Id, invite.SpaceId, invite.SpaceName, invite.Email)
+	invite.CreatedAt = time.Now()
+	// an expired invite to the same address is replaced, a pending one is kept
+	err := s.db.dbpool.QueryRow(context.Background(), `INSERT INTO invites (`+inviteColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (spaceid, email) DO UPDATE SET id=EXCLUDED.id, spaceName=EXCLUDED.spaceName, userid=EXCLUDED.userid,
+			invitedby=EXCLUDED.invitedby, createdat=EXCLUDED.createdat, expiresat=EXCLUDED.expiresat
+		WHERE invites.expiresat <= now()
+		RETURNING id`,
+		invite.Id, invite.SpaceId, invite.SpaceName, invite.Email, invite.UserId, invite.InvitedBy, invite.CreatedAt, invite.ExpiresAt).Scan(&invite.Id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errors.New("the address already has a pending invite to this space")
+	}
 	return err
 }
 
@@ -26,16 +46,60 @@ func (s *InviteService) DeleteInviteById(id uuid.UUID) error {
 	return err
 }
 
-func (s *InviteService) GetInvitesByUser(email string) ([]eligos.Invite, error) {
-	rows, err := s.db.dbpool.Query(context.Background(), "SELECT * FROM invites WHERE email=$1", email)
+func (s *InviteService) AcceptInvite(id uuid.UUID, role string) error {
+	ctx := context.Background()
+	tx, err := s.db.dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	var spaceid, userid uuid.UUID
+	err = tx.QueryRow(ctx, "DELETE FROM invites WHERE id=$1 AND userid IS NOT NULL AND expiresat > now() RETURNING spaceid, userid", id).Scan(&spaceid, &userid)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errors.New("invite not found or expired")
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO userspaces (userid, spaceid, role) VALUES ($1, $2, $3)", userid, spaceid, role)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *InviteService) GetInviteById(id uuid.UUID) (*eligos.Invite, error) {
+	invite, err := scanInvite(s.db.dbpool.QueryRow(context.Background(), "SELECT "+inviteColumns+" FROM invites WHERE id=$1", id))
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+func (s *InviteService) GetInvitesByUser(userid uuid.UUID) ([]eligos.Invite, error) {
+	return s.getInvites("SELECT "+inviteColumns+" FROM invites WHERE userid=$1 AND expiresat > now() ORDER BY createdat DESC", userid)
+}
+
+func (s *InviteService) GetInvitesBySpace(spaceid uuid.UUID) ([]eligos.Invite, error) {
+	return s.getInvites("SELECT "+inviteColumns+" FROM invites WHERE spaceid=$1 AND expiresat > now() ORDER BY createdat DESC", spaceid)
+}
+
+func (s *InviteService) GetInvitesSentBy(userid uuid.UUID) ([]eligos.Invite, error) {
+	return s.getInvites("SELECT "+inviteColumns+" FROM invites WHERE invitedby=$1 ORDER BY createdat DESC", userid)
+}
+
+func (s *InviteService) AttachInvites(email string, userid uuid.UUID) ([]eligos.Invite, error) {
+	return s.getInvites("UPDATE invites SET userid=$2 WHERE email=lower($1) AND userid IS NULL AND expiresat > now() RETURNING "+inviteColumns, email, userid)
+}
+
+func (s *InviteService) getInvites(query string, args ...any) ([]eligos.Invite, error) {
+	rows, err := s.db.dbpool.Query(context.Background(), query, args...)
 	defer rows.Close()
 	if err != nil {
 		return nil, err
 	}
 	invites, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (eligos.Invite, error) {
-		var invite eligos.Invite
-		err := row.Scan(&invite.Id, &invite.SpaceId, &invite.SpaceName, &invite.Email)
-		return invite, err
+		return scanInvite(row)
 	})
 	if err != nil {
 		return nil, err
@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type LoginAttemptService struct {
+	db *DB
+}
+
+func NewLoginAttemptService(db *DB) *LoginAttemptService {
+	return &LoginAttemptService{db: db}
+}
+
+func (s *LoginAttemptService) GetLockout(key string) (time.Time, error) {
+	var lockedUntil *time.Time
+	err := s.db.dbpool.QueryRow(context.Background(), "SELECT lockeduntil FROM loginattempts WHERE key=$1", key).Scan(&lockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if lockedUntil == nil {
+		return time.Time{}, nil
+	}
+	return *lockedUntil, nil
+}
+
+func (s *LoginAttemptService) RecordFailure(key string, window time.Duration) (int, error) {
+	now := time.Now()
+	var failures int
+	err := s.db.dbpool.QueryRow(context.Background(), `INSERT INTO loginattempts (key, failures, lastfailure) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN loginattempts.lastfailure < $3 THEN 1 ELSE loginattempts.failures + 1 END,
+			lastfailure = $2
+		RETURNING failures`, key, now, now.Add(-window)).Scan(&failures)
+	return failures, err
+}
+
+func (s *LoginAttemptService) SetLockout(key string, until time.Time) error {
+	_, err := s.db.dbpool.Exec(context.Background(), "UPDATE loginattempts SET lockeduntil=$2 WHERE key=$1", key, until)
+	return err
+}
+
+func (s *LoginAttemptService) ResetAttempts(key string) error {
+	_, err := s.db.dbpool.Exec(context.Background(), "DELETE FROM loginattempts WHERE key=$1", key)
+	return err
+}
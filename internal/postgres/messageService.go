@@ -5,7 +5,6 @@ import (
 	"github.com/arkreddy21/eligos"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
-	"time"
 )
 
 type MessageService struct {
@@ -16,25 +15,35 @@ func NewMessageService(db *DB) *MessageService {
 	return &MessageService{db: db}
 }
 
-func (s *MessageService) CreateMessage(m eligos.MessageWUser) (eligos.MessageWUser, error) {
-	m.CreatedAt = time.Now()
-	m.Id = uuid.New()
-	_, err := s.db.dbpool.Exec(context.Background(), "INSERT INTO messages VALUES ($1, $2, $3, $4, $5)", m.Id, m.UserId, m.SpaceId, m.Body, m.CreatedAt)
+func (s *MessageService) CreateMessage(userid, channelid uuid.UUID, body string) (eligos.MessageWUser, error) {
+	m := eligos.MessageWUser{Message: eligos.Message{Id: uuid.New(), UserId: userid, ChannelId: channelid, Body: body}}
+	m.User.Id = userid
+	err := s.db.dbpool.QueryRow(context.Background(), `WITH m AS (
+		INSERT INTO messages (id, userid, spaceid, channelid, body, createdat)
+		SELECT $1, $2, spaceid, id, $4, now() FROM channels WHERE id = $3
+		RETURNING spaceid, createdat
+	) SELECT m.spaceid, m.createdat, users.name, users.email FROM m, users WHERE users.id = $2`,
+		m.Id, userid, channelid, body).Scan(&m.SpaceId, &m.CreatedAt, &m.User.Name, &m.User.Email)
 	if err != nil {
 		return eligos.MessageWUser{}, err
 	}
 	return m, nil
 }
 
-func (s *MessageService) GetMessages(spaceid uuid.UUID) (*[]eligos.MessageWUser, error) {
-	rows, err := s.db.dbpool.Query(context.Background(), "SELECT messages.*, users.name, users.email FROM messages JOIN users ON messages.userid = users.id WHERE spaceid = $1 ORDER BY createdat", spaceid)
+func (s *MessageService) GetMessages(channelid uuid.UUID) (*[]eligos.MessageWUser, error) {
+	rows, err := s.db.dbpool.Query(context.Background(), "SELECT messages.id, messages.userid, messages.spaceid, messages.channelid, messages.body, messages.createdat, COALESCE(users.name, 'Deleted user'), COALESCE(users.email, '') FROM messages LEFT JOIN users ON messages.userid = users.id WHERE channelid = $1 ORDER BY createdat", channelid)
 	defer rows.Close()
 	if err != nil {
 		return nil, err
 	}
 	messages, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (eligos.MessageWUser, error) {
 		var message eligos.MessageWUser
-		err := row.Scan(&message.Id, &message.UserId, &message.SpaceId, &message.Body, &message.CreatedAt, &message.User.Name, &message.User.Email)
+		// userid is null if the author deleted their account, leaving the nil uuid
+		var userid *uuid.UUID
+		err := row.Scan(&message.Id, &userid, &message.SpaceId, &message.ChannelId, &message.Body, &message.CreatedAt, &message.User.Name, &message.User.Email)
+		if userid != nil {
+			message.UserId = *userid
+		}
 		message.User.Id = message.UserId
 		return message, err
 	})
@@ -43,3 +52,20 @@ func (s *MessageService) GetMessages(spaceid uuid.UUID) (*[]eligos.MessageWUser,
 	}
 	return &messages, nil
 }
+
+func (s *MessageService) GetMessagesByUser(userid uuid.UUID) ([]eligos.Message, error) {
+	rows, err := s.db.dbpool.Query(context.Background(), "SELECT id, userid, spaceid, channelid, body, createdat FROM messages WHERE userid = $1 ORDER BY createdat", userid)
+	defer rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	messages, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (eligos.Message, error) {
+		var message eligos.Message
+		err := row.Scan(&message.Id, &message.UserId, &message.SpaceId, &message.ChannelId, &message.Body, &message.CreatedAt)
+		return message, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
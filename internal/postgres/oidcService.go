@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"context"
+	"github.com/arkreddy21/eligos"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type OidcService struct {
+	db *DB
+}
+
+func NewOidcService(db *DB) *OidcService {
+	return &OidcService{db: db}
+}
+
+func (s *OidcService) CreateState(state *eligos.OidcState) error {
+	_, err := s.db.dbpool.Exec(context.Background(), "INSERT INTO oidcstates (statehash, nonce, codeverifier, expiresat) VALUES ($1, $2, $3, $4)",
+		state.StateHash, state.Nonce, state.CodeVerifier, state.ExpiresAt)
+	return err
+}
+
+func (s *OidcService) ConsumeState(stateHash string) (*eligos.OidcState, error) {
+	state := &eligos.OidcState{}
+	err := s.db.dbpool.QueryRow(context.Background(), "DELETE FROM oidcstates WHERE statehash=$1 AND expiresat > $2 RETURNING statehash, nonce, codeverifier, expiresat", stateHash, time.Now()).
+		Scan(&state.StateHash, &state.Nonce, &state.CodeVerifier, &state.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *OidcService) GetUserByIdentity(issuer, subject string) (*eligos.User, error) {
+	return scanUser(s.db.dbpool.QueryRow(context.Background(), "SELECT "+userColumns+" FROM users WHERE id=(SELECT userid FROM identities WHERE issuer=$1 AND subject=$2)", issuer, subject))
+}
+
+func (s *OidcService) LinkIdentity(userid uuid.UUID, issuer, subject string) error {
+	_, err := s.db.dbpool.Exec(context.Background(), "INSERT INTO identities (issuer, subject, userid) VALUES ($1, $2, $3)", issuer, subject, userid)
+	return err
+}
+
+func (s *OidcService) ClaimUser(userid uuid.UUID, issuer, subject string) ([]uuid.UUID, error) {
+	ctx := context.Background()
+	tx, err := s.db.dbpool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	queries := []string{
+		"UPDATE users SET password='', emailverified=true, totpsecret='', totpenabled=false, totplaststep=0, recoverycodes='{}' WHERE id=$1",
+		"DELETE FROM apitokens WHERE userid=$1",
+		"DELETE FROM usertokens WHERE userid=$1",
+	}
+	for _, query := range queries {
+		_, err = tx.Exec(ctx, query, userid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rows, err := tx.Query(ctx, "UPDATE sessions SET revoked=true WHERE userid=$1 AND NOT revoked RETURNING id", userid)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO identities (issuer, subject, userid) VALUES ($1, $2, $3)", issuer, subject, userid)
+	if err != nil {
+		return nil, err
+	}
+	return revoked, tx.Commit(ctx)
+}
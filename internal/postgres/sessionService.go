@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"github.com/arkreddy21/eligos"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type SessionService struct {
+	db *DB
+}
+
+func NewSessionService(db *DB) *SessionService {
+	return &SessionService{db: db}
+}
+
+const sessionColumns = "id, userid, refreshhash, previoushashes, createdat, lastusedat, expiresat, revoked, devicelabel, ip, useragent"
+
+// Number of rotated refresh token hashes kept to detect replays
+const previousHashCount = 10
+
+func scanSession(row pgx.Row) (eligos.Session, error) {
+	var session eligos.Session
+	err := row.Scan(&session.Id, &session.UserId, &session.RefreshHash, &session.PreviousHashes, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt,
+		&session.Revoked, &session.DeviceLabel, &session.Ip, &session.UserAgent)
+	return session, err
+}
+
+func (s *SessionService) CreateSession(session *eligos.Session) error {
+	session.Id = uuid.New()
+	session.CreatedAt = time.Now()
+	session.LastUsedAt = session.CreatedAt
+	if session.PreviousHashes == nil {
+		session.PreviousHashes = []string{}
+	}
+	_, err := s.db.dbpool.Exec(context.Background(), "INSERT INTO sessions ("+sessionColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)",
+		session.Id, session.UserId, session.RefreshHash, session.PreviousHashes, session.CreatedAt, session.LastUsedAt, session.ExpiresAt,
+		session.Revoked, session.DeviceLabel, session.Ip, session.UserAgent)
+	return err
+}
+
+func (s *SessionService) GetSession(id uuid.UUID) (*eligos.Session, error) {
+	session, err := scanSession(s.db.dbpool.QueryRow(context.Background(), "SELECT "+sessionColumns+" FROM sessions WHERE id=$1", id))
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RotateSession replaces the refresh token hash of a session and extends its expiry.
+// The old hash is checked in the same statement so only one of concurrent refreshes wins.
+func (s *SessionService) RotateSession(id uuid.UUID, oldHash, newHash string, expiresAt time.Time) (bool, error) {
+	tag, err := s.db.dbpool.Exec(context.Background(), `UPDATE sessions SET refreshhash=$3, previoushashes=(refreshhash || previoushashes)[1:$6], expiresat=$4, lastusedat=$5
+		WHERE id=$1 AND refreshhash=$2 AND NOT revoked`,
+		id, oldHash, newHash, expiresAt, time.Now(), previousHashCount)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (s *SessionService) RevokeSession(id uuid.UUID) error {
+	_, err := s.db.dbpool.Exec(context.Background(), "UPDATE sessions SET revoked=true WHERE id=$1", id)
+	return err
+}
+
+// TouchSession records that a session has just been used.
+// Writes are skipped if the session was already used within the last minute.
+func (s *SessionService) TouchSession(id uuid.UUID) error {
+	now := time.Now()
+	_, err := s.db.dbpool.Exec(context.Background(), "UPDATE sessions SET lastusedat=$2 WHERE id=$1 AND lastusedat < $3", id, now, now.Add(-time.Minute))
+	return err
+}
+
+// GetSessionsByUser returns all active sessions of a user, most recently used first
+func (s *SessionService) GetSessionsByUser(userid uuid.UUID) ([]eligos.Session, error) {
+	rows, err := s.db.dbpool.Query(context.Background(), "SELECT "+sessionColumns+" FROM sessions WHERE userid=$1 AND NOT revoked AND expiresat > $2 ORDER BY lastusedat DESC", userid, time.Now())
+	defer rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (eligos.Session, error) {
+		return scanSession(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeOtherSessions revokes every active session of a user except keep and returns the ids of revoked sessions
+func (s *SessionService) RevokeOtherSessions(userid, keep uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := s.db.dbpool.Query(context.Background(), "UPDATE sessions SET revoked=true WHERE userid=$1 AND id<>$2 AND NOT revoked RETURNING id", userid, keep)
+	defer rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
+
+// RevokeAllSessions revokes every active session of a user and returns the ids of revoked sessions
+func (s *SessionService) RevokeAllSessions(userid uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := s.db.dbpool.Query(context.Background(), "UPDATE sessions SET revoked=true WHERE userid=$1 AND NOT revoked RETURNING id", userid)
+	defer rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
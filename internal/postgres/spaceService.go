@@ -2,9 +2,13 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"github.com/arkreddy21/eligos"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"slices"
+	"strings"
+	"time"
 )
 
 type SpaceService struct {
@@ -15,18 +19,153 @@ func NewSpaceService(db *DB) *SpaceService {
 	return &SpaceService{db: db}
 }
 
+const spaceColumns = "s.id, s.name, s.kind, s.visibility, s.description, s.topic, s.icon, s.createdat, s.createdby, s.archivedat"
+
+// scanSpace scans the spaceColumns of a row, followed by any extra columns
+func scanSpace(row pgx.Row, extra ...any) (eligos.Space, error) {
+	var space eligos.Space
+	dest := []any{&space.Id, &space.Name, &space.Kind, &space.Visibility, &space.Description, &space.Topic, &space.Icon, &space.CreatedAt, &space.CreatedBy, &space.ArchivedAt}
+	err := row.Scan(append(dest, extra...)...)
+	return space, err
+}
+
 func (s *SpaceService) CreateSpace(space *eligos.Space, userid uuid.UUID) error {
+	ctx := context.Background()
 	space.Id = uuid.New()
-	_, err := s.db.dbpool.Exec(context.Background(), "INSERT INTO spaces VALUES ($1, $2)", space.Id, space.Name)
+	tx, err := s.db.dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	space.Kind = eligos.SpaceKindSpace
+	if space.Visibility == "" {
+		space.Visibility = eligos.VisibilityPrivate
+	}
+	space.CreatedAt = time.Now()
+	space.CreatedBy = &userid
+	_, err = tx.Exec(ctx, "INSERT INTO spaces (id, name, kind, visibility, description, topic, icon, createdat, createdby) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		space.Id, space.Name, space.Kind, space.Visibility, space.Description, space.Topic, space.Icon, space.CreatedAt, space.CreatedBy)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO userspaces (userid, spaceid, role) VALUES ($1, $2, $3)", userid, space.Id, eligos.RoleOwner)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO channels (id, spaceid, name, isdefault, createdat) VALUES ($1, $2, $3, true, $4)", uuid.New(), space.Id, eligos.DefaultChannelName, time.Now())
 	if err != nil {
 		return err
 	}
-	_, err = s.db.dbpool.Exec(context.Background(), "INSERT INTO userspaces VALUES ($1, $2)", userid, space.Id)
+	return tx.Commit(ctx)
+}
+
+func (s *SpaceService) GetOrCreateDm(userids []uuid.UUID) (*eligos.Space, bool, error) {
+	ctx := context.Background()
+	ids := make([]string, 0, len(userids))
+	for _, id := range userids {
+		ids = append(ids, id.String())
+	}
+	slices.Sort(ids)
+	key := strings.Join(slices.Compact(ids), ",")
+
+	space := &eligos.Space{Id: uuid.New(), Kind: eligos.SpaceKindDm, Visibility: eligos.VisibilityPrivate, CreatedAt: time.Now(), CreatedBy: &userids[0]}
+	tx, err := s.db.dbpool.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+	// a concurrent request for the same users waits here until the other one commits
+	tag, err := tx.Exec(ctx, "INSERT INTO spaces (id, name, kind, visibility, createdat, createdby, participantkey) VALUES ($1, '', $2, $3, $4, $5, $6) ON CONFLICT (participantkey) DO NOTHING",
+		space.Id, space.Kind, space.Visibility, space.CreatedAt, space.CreatedBy, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if tag.RowsAffected() == 0 {
+		existing, err := scanSpace(tx.QueryRow(ctx, "SELECT "+spaceColumns+" FROM spaces s WHERE s.participantkey=$1", key))
+		if err != nil {
+			return nil, false, err
+		}
+		for _, userid := range userids {
+			_, err = tx.Exec(ctx, "INSERT INTO userspaces (userid, spaceid, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", userid, existing.Id, eligos.RoleMember)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		err = tx.Commit(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		return &existing, false, nil
+	}
+	for _, userid := range userids {
+		_, err = tx.Exec(ctx, "INSERT INTO userspaces (userid, spaceid, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", userid, space.Id, eligos.RoleMember)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO channels (id, spaceid, name, isdefault, createdat) VALUES ($1, $2, $3, true, $4)", uuid.New(), space.Id, eligos.DefaultChannelName, time.Now())
+	if err != nil {
+		return nil, false, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	return space, true, nil
+}
+
+func (s *SpaceService) GetSpace(id uuid.UUID) (*eligos.Space, error) {
+	space, err := scanSpace(s.db.dbpool.QueryRow(context.Background(), "SELECT "+spaceColumns+" FROM spaces s WHERE s.id=$1", id))
+	if err != nil {
+		return nil, err
+	}
+	return &space, nil
+}
+
+func (s *SpaceService) SetVisibility(spaceid uuid.UUID, visibility string) error {
+	_, err := s.db.dbpool.Exec(context.Background(), "UPDATE spaces SET visibility=$2 WHERE id=$1 AND kind='space'", spaceid, visibility)
+	return err
+}
+
+func (s *SpaceService) UpdateSpace(space *eligos.Space) error {
+	_, err := s.db.dbpool.Exec(context.Background(), "UPDATE spaces SET name=$2, description=$3, topic=$4, icon=$5 WHERE id=$1",
+		space.Id, space.Name, space.Description, space.Topic, space.Icon)
+	return err
+}
+
+func (s *SpaceService) SetArchived(spaceid uuid.UUID, archived bool) error {
+	var archivedAt *time.Time
+	if archived {
+		now := time.Now()
+		archivedAt = &now
+	}
+	_, err := s.db.dbpool.Exec(context.Background(), "UPDATE spaces SET archivedat=$2 WHERE id=$1", spaceid, archivedAt)
 	return err
 }
 
-func (s *SpaceService) AddUserById(userid, spaceid uuid.UUID) error {
-	_, err := s.db.dbpool.Exec(context.Background(), "INSERT INTO userspaces VALUES ($1, $2)", userid, spaceid)
+func (s *SpaceService) GetPublicSpaces(query string, limit, offset int) ([]eligos.SpaceListing, error) {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	rows, err := s.db.dbpool.Query(context.Background(), `SELECT `+spaceColumns+`, (SELECT count(*) FROM userspaces us WHERE us.spaceid = s.id) AS members
+		FROM spaces s WHERE s.visibility = $1 AND s.archivedat IS NULL AND s.name ILIKE $2
+		ORDER BY members DESC, s.name, s.id LIMIT $3 OFFSET $4`, eligos.VisibilityPublic, pattern, limit, offset)
+	defer rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	spaces, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (eligos.SpaceListing, error) {
+		var listing eligos.SpaceListing
+		space, err := scanSpace(row, &listing.MemberCount)
+		listing.Space = space
+		return listing, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return spaces, nil
+}
+
+func (s *SpaceService) AddUserById(userid, spaceid uuid.UUID, role string) error {
+	_, err := s.db.dbpool.Exec(context.Background(), "INSERT INTO userspaces (userid, spaceid, role) VALUES ($1, $2, $3)", userid, spaceid, role)
 	return err
 }
 
@@ -47,12 +186,159 @@ func (s *SpaceService) GetUsersInSpace(spaceid uuid.UUID) (*[]eligos.User, error
 	return &users, nil
 }
 
+func (s *SpaceService) GetMembers(spaceid uuid.UUID) ([]eligos.Member, error) {
+	rows, err := s.db.dbpool.Query(context.Background(), "SELECT u.id, u.name, us.role, us.mutedUntil FROM users u JOIN userspaces us ON u.id=us.userid WHERE us.spaceid=$1 ORDER BY u.name", spaceid)
+	defer rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	members, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (eligos.Member, error) {
+		var member eligos.Member
+		err := row.Scan(&member.UserId, &member.Name, &member.Role, &member.MutedUntil)
+		return member, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (s *SpaceService) GetMember(userid, spaceid uuid.UUID) (*eligos.Member, error) {
+	var member eligos.Member
+	err := s.db.dbpool.QueryRow(context.Background(), "SELECT u.id, u.name, us.role, us.mutedUntil FROM users u JOIN userspaces us ON u.id=us.userid WHERE us.userid=$1 AND us.spaceid=$2", userid, spaceid).Scan(&member.UserId, &member.Name, &member.Role, &member.MutedUntil)
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (s *SpaceService) GetRole(userid, spaceid uuid.UUID) (string, error) {
+	var role string
+	err := s.db.dbpool.QueryRow(context.Background(), "SELECT role FROM userspaces WHERE userid=$1 AND spaceid=$2", userid, spaceid).Scan(&role)
+	if err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+func (s *SpaceService) SetRole(userid, spaceid uuid.UUID, role string) error {
+	tag, err := s.db.dbpool.Exec(context.Background(), "UPDATE userspaces SET role=$3 WHERE userid=$1 AND spaceid=$2", userid, spaceid, role)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("user is not a member of the space")
+	}
+	return nil
+}
+
+func (s *SpaceService) SetMute(userid, spaceid uuid.UUID, until *time.Time) error {
+	tag, err := s.db.dbpool.Exec(context.Background(), "UPDATE userspaces SET mutedUntil=$3 WHERE userid=$1 AND spaceid=$2", userid, spaceid, until)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("user is not a member of the space")
+	}
+	return nil
+}
+
+func (s *SpaceService) LeaveSpace(userid, spaceid uuid.UUID) error {
+	ctx := context.Background()
+	tx, err := s.db.dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	// owners leaving at the same time wait for each other here, so the last one sees it is the last
+	rows, err := tx.Query(ctx, "SELECT userid FROM userspaces WHERE spaceid=$1 AND role=$2 ORDER BY userid FOR UPDATE", spaceid, eligos.RoleOwner)
+	if err != nil {
+		return err
+	}
+	owners, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return err
+	}
+	if len(owners) == 1 && owners[0] == userid {
+		return eligos.ErrLastOwner
+	}
+	tag, err := tx.Exec(ctx, "DELETE FROM userspaces WHERE userid=$1 AND spaceid=$2", userid, spaceid)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("user is not a member of the space")
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *SpaceService) TransferOwnership(spaceid, from, to uuid.UUID) error {
+	ctx := context.Background()
+	tx, err := s.db.dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	tag, err := tx.Exec(ctx, "UPDATE userspaces SET role=$3 WHERE userid=$1 AND spaceid=$2", to, spaceid, eligos.RoleOwner)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("user is not a member of the space")
+	}
+	tag, err = tx.Exec(ctx, "UPDATE userspaces SET role=$3 WHERE userid=$1 AND spaceid=$2 AND role=$4", from, spaceid, eligos.RoleAdmin, eligos.RoleOwner)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("user is not an owner of the space")
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *SpaceService) GetSoleOwnedSpaces(userid uuid.UUID) ([]eligos.Space, error) {
+	rows, err := s.db.dbpool.Query(context.Background(), `SELECT `+spaceColumns+` FROM spaces s JOIN userspaces us ON us.spaceid = s.id
+		WHERE us.userid=$1 AND us.role=$2 AND NOT EXISTS (SELECT 1 FROM userspaces o WHERE o.spaceid = s.id AND o.role=$2 AND o.userid <> $1)`, userid, eligos.RoleOwner)
+	defer rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	spaces, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (eligos.Space, error) {
+		return scanSpace(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return spaces, nil
+}
+
 func (s *SpaceService) RemoveUserById(userid, spaceid uuid.UUID) error {
 	_, err := s.db.dbpool.Exec(context.Background(), "DELETE FROM userspaces WHERE userid=$1 AND spaceid=$2", userid, spaceid)
 	return err
 }
 
 func (s *SpaceService) DeleteSpaceById(spaceid uuid.UUID) error {
-	_, err := s.db.dbpool.Exec(context.Background(), "DELETE FROM spaces WHERE id=$1", spaceid)
-	return err
+	ctx := context.Background()
+	tx, err := s.db.dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	// dependent rows first, the audit log is kept
+	queries := []string{
+		"DELETE FROM messages WHERE spaceid=$1",
+		"DELETE FROM channels WHERE spaceid=$1",
+		"DELETE FROM invites WHERE spaceid=$1",
+		"DELETE FROM invitelinks WHERE spaceid=$1",
+		"DELETE FROM bans WHERE spaceid=$1",
+		"DELETE FROM userspaces WHERE spaceid=$1",
+		"DELETE FROM spaces WHERE id=$1",
+	}
+	for _, query := range queries {
+		_, err = tx.Exec(ctx, query, spaceid)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
 }
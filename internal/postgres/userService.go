@@ -15,39 +15,48 @@ func NewUserService(db *DB) *UserService {
 	return &UserService{db: db}
 }
 
-func (s *UserService) CreateUser(u *eligos.User) error {
-	u.Id = uuid.New()
-	_, err := s.db.dbpool.Exec(context.Background(), "INSERT INTO users VALUES ($1, $2, $3, $4)", u.Id, u.Name, u.Email, u.Password)
-	return err
-}
+const userColumns = "id, name, email, password, emailverified, totpsecret, totpenabled, recoverycodes"
 
-func (s *UserService) GetUser(email string) (*eligos.User, error) {
+func scanUser(row pgx.Row) (*eligos.User, error) {
 	user := &eligos.User{}
-	err := s.db.dbpool.QueryRow(context.Background(), "SELECT * FROM users WHERE email=$1", email).Scan(&user.Id, &user.Name, &user.Email, &user.Password)
+	err := row.Scan(&user.Id, &user.Name, &user.Email, &user.Password, &user.EmailVerified, &user.TotpSecret, &user.TotpEnabled, &user.RecoveryCodes)
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (s *UserService) GetUserById(id uuid.UUID) (*eligos.User, error) {
-	user := &eligos.User{}
-	err := s.db.dbpool.QueryRow(context.Background(), "SELECT * FROM users WHERE id=$1", id).Scan(&user.Id, &user.Name, &user.Email, &user.Password)
-	if err != nil {
-		return nil, err
+func (s *UserService) CreateUser(u *eligos.User) error {
+	u.Id = uuid.New()
+	if u.RecoveryCodes == nil {
+		u.RecoveryCodes = []string{}
 	}
-	return user, nil
+	_, err := s.db.dbpool.Exec(context.Background(), "INSERT INTO users ("+userColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		u.Id, u.Name, u.Email, u.Password, u.EmailVerified, u.TotpSecret, u.TotpEnabled, u.RecoveryCodes)
+	return err
+}
+
+func (s *UserService) GetUser(email string) (*eligos.User, error) {
+	return scanUser(s.db.dbpool.QueryRow(context.Background(), "SELECT "+userColumns+" FROM users WHERE lower(email)=lower($1)", email))
+}
+
+func (s *UserService) GetUserById(id uuid.UUID) (*eligos.User, error) {
+	return scanUser(s.db.dbpool.QueryRow(context.Background(), "SELECT "+userColumns+" FROM users WHERE id=$1", id))
 }
 
 func (s *UserService) GetSpaces(userid uuid.UUID) (*[]eligos.Space, error) {
-	rows, err := s.db.dbpool.Query(context.Background(), "SELECT s.id, s.name FROM spaces s JOIN userspaces us ON s.id = us.spaceid WHERE us.userid=$1", userid)
+	// dms are named after the other participants
+	rows, err := s.db.dbpool.Query(context.Background(), `SELECT `+spaceColumns+`,
+		CASE WHEN s.kind = 'dm' THEN COALESCE((SELECT string_agg(u.name, ', ' ORDER BY u.name) FROM userspaces p JOIN users u ON u.id = p.userid WHERE p.spaceid = s.id AND p.userid <> $1), '') ELSE s.name END
+		FROM spaces s JOIN userspaces us ON s.id = us.spaceid WHERE us.userid=$1`, userid)
 	defer rows.Close()
 	if err != nil {
 		return nil, err
 	}
 	spaces, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (eligos.Space, error) {
-		var space eligos.Space
-		err := row.Scan(&space.Id, &space.Name)
+		var name string
+		space, err := scanSpace(row, &name)
+		space.Name = name
 		return space, err
 	})
 	if err != nil {
@@ -55,3 +64,94 @@ func (s *UserService) GetSpaces(userid uuid.UUID) (*[]eligos.Space, error) {
 	}
 	return &spaces, nil
 }
+
+func (s *UserService) SetEmailVerified(userid uuid.UUID) error {
+	_, err := s.db.dbpool.Exec(context.Background(), "UPDATE users SET emailverified=true WHERE id=$1", userid)
+	return err
+}
+
+func (s *UserService) UpdatePassword(userid uuid.UUID, password string) error {
+	_, err := s.db.dbpool.Exec(context.Background(), "UPDATE users SET password=$2 WHERE id=$1", userid, password)
+	return err
+}
+
+func (s *UserService) SetTotp(userid uuid.UUID, secret string, enabled bool, recoveryCodes []string) error {
+	if recoveryCodes == nil {
+		recoveryCodes = []string{}
+	}
+	_, err := s.db.dbpool.Exec(context.Background(), "UPDATE users SET totpsecret=$2, totpenabled=$3, recoverycodes=$4, totplaststep=0 WHERE id=$1", userid, secret, enabled, recoveryCodes)
+	return err
+}
+
+func (s *UserService) UseTotpStep(userid uuid.UUID, step int64) (bool, error) {
+	tag, err := s.db.dbpool.Exec(context.Background(), "UPDATE users SET totplaststep=$2 WHERE id=$1 AND totplaststep < $2", userid, step)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (s *UserService) UseRecoveryCode(userid uuid.UUID, hash string) (bool, error) {
+	tag, err := s.db.dbpool.Exec(context.Background(), "UPDATE users SET recoverycodes=array_remove(recoverycodes, $2) WHERE id=$1 AND $2=ANY(recoverycodes)", userid, hash)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (s *UserService) UpdateName(userid uuid.UUID, name string) error {
+	_, err := s.db.dbpool.Exec(context.Background(), "UPDATE users SET name=$2 WHERE id=$1", userid, name)
+	return err
+}
+
+func (s *UserService) UpdateEmail(userid uuid.UUID, email string) error {
+	_, err := s.db.dbpool.Exec(context.Background(), "UPDATE users SET email=$2, emailverified=true WHERE id=$1", userid, email)
+	return err
+}
+
+func (s *UserService) DeleteUser(userid uuid.UUID) error {
+	ctx := context.Background()
+	tx, err := s.db.dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	// lock the owners of every space the user owns, so co-owners can't leave meanwhile
+	_, err = tx.Exec(ctx, `SELECT 1 FROM userspaces WHERE role=$2
+		AND spaceid IN (SELECT spaceid FROM userspaces WHERE userid=$1 AND role=$2)
+		ORDER BY spaceid, userid FOR UPDATE`, userid, eligos.RoleOwner)
+	if err != nil {
+		return err
+	}
+	var soleOwned bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM userspaces us WHERE us.userid=$1 AND us.role=$2
+		AND NOT EXISTS (SELECT 1 FROM userspaces o WHERE o.spaceid=us.spaceid AND o.role=$2 AND o.userid<>$1))`, userid, eligos.RoleOwner).Scan(&soleOwned)
+	if err != nil {
+		return err
+	}
+	if soleOwned {
+		return eligos.ErrLastOwner
+	}
+	queries := []string{
+		"UPDATE messages SET userid=NULL WHERE userid=$1",
+		"DELETE FROM invites WHERE userid=$1 OR email=(SELECT lower(email) FROM users WHERE id=$1)",
+		"DELETE FROM userspaces WHERE userid=$1",
+		"DELETE FROM bans WHERE userid=$1",
+		"UPDATE bans SET bannedby=NULL WHERE bannedby=$1",
+		"DELETE FROM sessions WHERE userid=$1",
+		"DELETE FROM usertokens WHERE userid=$1",
+		"DELETE FROM apitokens WHERE userid=$1",
+		"DELETE FROM identities WHERE userid=$1",
+		"UPDATE spaces SET createdby=NULL WHERE createdby=$1",
+		"UPDATE invitelinks SET createdby=NULL WHERE createdby=$1",
+		"UPDATE invites SET invitedby=NULL WHERE invitedby=$1",
+		"DELETE FROM users WHERE id=$1",
+	}
+	for _, query := range queries {
+		_, err = tx.Exec(ctx, query, userid)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
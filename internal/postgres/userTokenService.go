@@ -0,0 +1,38 @@
+package postgres
+
+import (
+	"context"
+	"github.com/arkreddy21/eligos"
+	"github.com/google/uuid"
+	"time"
+)
+
+type UserTokenService struct {
+	db *DB
+}
+
+func NewUserTokenService(db *DB) *UserTokenService {
+	return &UserTokenService{db: db}
+}
+
+func (s *UserTokenService) CreateToken(token *eligos.UserToken) error {
+	_, err := s.db.dbpool.Exec(context.Background(), "INSERT INTO usertokens (hash, userid, purpose, email, expiresat) VALUES ($1, $2, $3, $4, $5)",
+		token.Hash, token.UserId, token.Purpose, token.Email, token.ExpiresAt)
+	return err
+}
+
+func (s *UserTokenService) ConsumeToken(hash, purpose string) (*eligos.UserToken, error) {
+	token := &eligos.UserToken{}
+	err := s.db.dbpool.QueryRow(context.Background(), "DELETE FROM usertokens WHERE hash=$1 AND purpose=$2 AND expiresat > $3 RETURNING hash, userid, purpose, email, expiresat", hash, purpose, time.Now()).
+		Scan(&token.Hash, &token.UserId, &token.Purpose, &token.Email, &token.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// DeleteTokens deletes every outstanding token of a user with the given purpose
+func (s *UserTokenService) DeleteTokens(userid uuid.UUID, purpose string) error {
+	_, err := s.db.dbpool.Exec(context.Background(), "DELETE FROM usertokens WHERE userid=$1 AND purpose=$2", userid, purpose)
+	return err
+}
@@ -1,29 +1,70 @@
 CREATE TABLE IF NOT EXISTS users
 (
-    id       uuid primary key,
-    name     varchar(50) not null,
-    email    text unique not null,
-    password text        not null
+    id            uuid primary key,
+    name          varchar(50) not null,
+    email         text unique not null,
+    password      text        not null,
+    emailverified boolean     not null default false,
+    totpsecret    text        not null default '',
+    totpenabled   boolean     not null default false,
+    totplaststep  bigint      not null default 0,
+    recoverycodes text[]      not null default '{}'
 );
+-- addresses are compared without case, new ones are stored in lower case
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower ON users (lower(email));
 
 CREATE TABLE IF NOT EXISTS spaces
 (
-    id   uuid primary key,
-    name varchar(50) not null
+    id             uuid primary key,
+    name           varchar(50) not null,
+    -- space or dm
+    kind           text        not null default 'space',
+    -- private, public or unlisted
+    visibility     text        not null default 'private',
+    description    text        not null default '',
+    topic          text        not null default '',
+    icon           text        not null default '',
+    createdat      timestamptz not null default now(),
+    -- null once the creator deleted their account
+    createdby      uuid references users (id),
+    -- archived spaces are read-only, null if active
+    archivedat     timestamptz,
+    -- sorted ids of the participants of a dm, so a set of users always shares one conversation
+    participantkey text unique
 );
 
 CREATE TABLE IF NOT EXISTS userspaces
 (
-    userid  uuid not null references users (id),
-    spaceid uuid not null references spaces (id),
+    userid     uuid not null references users (id),
+    spaceid    uuid not null references spaces (id),
+    -- owner, admin, member or guest
+    role       text not null default 'member',
+    -- the member can't post until this time
+    mutedUntil timestamptz,
     UNIQUE (userid, spaceid)
 );
 
+CREATE TABLE IF NOT EXISTS channels
+(
+    id        uuid primary key,
+    spaceid   uuid        not null references spaces (id),
+    name      text        not null,
+    topic     text        not null default '',
+    -- the channel a space is created with, it can't be deleted
+    isdefault boolean     not null default false,
+    -- permissions granted or denied by role, replacing what the role allows in the space
+    overrides jsonb       not null default '{}',
+    createdat timestamptz not null,
+    UNIQUE (spaceid, name)
+);
+
 CREATE TABLE IF NOT EXISTS messages
 (
     id        uuid primary key,
-    userid    uuid        not null references users (id),
+    -- null once the author deleted their account
+    userid    uuid references users (id),
     spaceid   uuid        not null references spaces (id),
+    channelid uuid        not null references channels (id),
     body      text        not null,
     createdat timestamptz not null
 );
@@ -34,5 +75,217 @@ CREATE TABLE IF NOT EXISTS invites
     spaceid   uuid not null references spaces (id),
     spaceName text not null,
     email     text not null,
+    -- null until someone registers and verifies the invited address
+    userid    uuid references users (id),
+    invitedby uuid references users (id),
+    createdat timestamptz not null default now(),
+    expiresat timestamptz not null,
     UNIQUE (spaceid, email)
 );
+
+CREATE TABLE IF NOT EXISTS sessions
+(
+    id          uuid primary key,
+    userid      uuid        not null references users (id),
+    refreshhash text        not null,
+    -- hashes of the last refresh tokens rotated away, to detect replays
+    previoushashes text[]   not null default '{}',
+    createdat   timestamptz not null,
+    lastusedat  timestamptz not null,
+    expiresat   timestamptz not null,
+    revoked     boolean     not null default false,
+    devicelabel text        not null default '',
+    ip          text        not null default '',
+    useragent   text        not null default ''
+);
+
+
+-- single use tokens mailed to a user, e.g. to verify their email address
+CREATE TABLE IF NOT EXISTS usertokens
+(
+    hash      text primary key,
+    userid    uuid        not null references users (id),
+    purpose   text        not null,
+    email     text        not null,
+    expiresat timestamptz not null
+);
+
+-- accounts at an external OpenID Connect provider linked to a user
+CREATE TABLE IF NOT EXISTS identities
+(
+    issuer  text not null,
+    subject text not null,
+    userid  uuid not null references users (id),
+    PRIMARY KEY (issuer, subject)
+);
+
+-- pending OpenID Connect logins, keyed by the hash of the state parameter
+CREATE TABLE IF NOT EXISTS oidcstates
+(
+    statehash    text primary key,
+    nonce        text        not null,
+    codeverifier text        not null,
+    expiresat    timestamptz not null
+);
+
+CREATE TABLE IF NOT EXISTS apitokens
+(
+    id         uuid primary key,
+    userid     uuid        not null references users (id),
+    name       varchar(50) not null,
+    hash       text unique not null,
+    scopes     text[]      not null,
+    createdat  timestamptz not null,
+    lastusedat timestamptz,
+    expiresat  timestamptz
+);
+
+-- failed login and registration attempts per account or ip, shared by all instances
+CREATE TABLE IF NOT EXISTS loginattempts
+(
+    key         text primary key,
+    failures    int         not null,
+    lastfailure timestamptz not null,
+    lockeduntil timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS bans
+(
+    spaceid   uuid        not null references spaces (id),
+    userid    uuid        not null references users (id),
+    -- null once the moderator deleted their account
+    bannedby  uuid references users (id),
+    reason    text        not null default '',
+    createdat timestamptz not null,
+    UNIQUE (spaceid, userid)
+);
+
+-- administrative actions in spaces. No foreign keys so entries outlive the users and spaces they mention.
+CREATE TABLE IF NOT EXISTS auditlog
+(
+    id        bigint generated always as identity primary key,
+    spaceid   uuid        not null,
+    actorid   uuid        not null,
+    targetid  uuid,
+    action    text        not null,
+    metadata  jsonb       not null default '{}',
+    createdat timestamptz not null
+);
+CREATE INDEX IF NOT EXISTS auditlog_spaceid ON auditlog (spaceid, id);
+
+CREATE OR REPLACE FUNCTION auditlog_append_only() RETURNS trigger AS
+$$
+BEGIN
+    RAISE EXCEPTION 'auditlog is append-only';
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS auditlog_append_only ON auditlog;
+CREATE TRIGGER auditlog_append_only
+    BEFORE UPDATE OR DELETE ON auditlog
+    FOR EACH ROW EXECUTE FUNCTION auditlog_append_only();
+
+CREATE TABLE IF NOT EXISTS invitelinks
+(
+    id        uuid primary key,
+    spaceid   uuid        not null references spaces (id),
+    -- sha256 of the token, the token itself is only shown when the link is created
+    hash      text unique not null,
+    role      text        not null,
+    -- null for unlimited uses
+    maxuses   int,
+    uses      int         not null default 0,
+    expiresat timestamptz,
+    -- null once the creator deleted their account
+    createdby uuid references users (id),
+    createdat timestamptz not null
+);
+
+-- Upgrades a database created by an earlier version of this file. CREATE TABLE IF NOT EXISTS
+-- leaves existing tables alone, so columns added since are added here. Every statement is
+-- safe to run again, backfills only run when their column is first added.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totpsecret text not null default '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totpenabled boolean not null default false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totplaststep bigint not null default 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS recoverycodes text[] not null default '{}';
+-- accounts that existed before addresses were verified keep working
+ALTER TABLE users ADD COLUMN IF NOT EXISTS emailverified boolean not null default true;
+ALTER TABLE users ALTER COLUMN emailverified SET DEFAULT false;
+-- invites are matched to users by their lower case address
+UPDATE invites i SET email = lower(i.email)
+WHERE i.email <> lower(i.email)
+  AND NOT EXISTS (SELECT 1 FROM invites o WHERE o.spaceid = i.spaceid AND o.email = lower(i.email));
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS previoushashes text[] not null default '{}';
+
+ALTER TABLE spaces ADD COLUMN IF NOT EXISTS kind text not null default 'space';
+ALTER TABLE spaces ADD COLUMN IF NOT EXISTS visibility text not null default 'private';
+ALTER TABLE spaces ADD COLUMN IF NOT EXISTS description text not null default '';
+ALTER TABLE spaces ADD COLUMN IF NOT EXISTS topic text not null default '';
+ALTER TABLE spaces ADD COLUMN IF NOT EXISTS icon text not null default '';
+ALTER TABLE spaces ADD COLUMN IF NOT EXISTS createdat timestamptz not null default now();
+ALTER TABLE spaces ADD COLUMN IF NOT EXISTS createdby uuid references users (id);
+ALTER TABLE spaces ADD COLUMN IF NOT EXISTS archivedat timestamptz;
+ALTER TABLE spaces ADD COLUMN IF NOT EXISTS participantkey text;
+-- named like the constraint CREATE TABLE makes, so new databases don't get a second index
+CREATE UNIQUE INDEX IF NOT EXISTS spaces_participantkey_key ON spaces (participantkey);
+
+ALTER TABLE userspaces ADD COLUMN IF NOT EXISTS mutedUntil timestamptz;
+DO
+$$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_schema = current_schema() AND table_name = 'userspaces' AND column_name = 'role') THEN
+        ALTER TABLE userspaces ADD COLUMN role text not null default 'member';
+        -- rows were never updated before roles, so the first row of a space is the one its creator got
+        UPDATE userspaces SET role = 'owner'
+        WHERE ctid IN (SELECT DISTINCT ON (spaceid) ctid FROM userspaces ORDER BY spaceid, ctid);
+        UPDATE spaces s SET createdby = us.userid
+        FROM userspaces us
+        WHERE us.spaceid = s.id AND us.role = 'owner' AND s.createdby IS NULL;
+    END IF;
+END
+$$;
+
+-- every space needs the default channel messages without a channel go to
+INSERT INTO channels (id, spaceid, name, isdefault, createdat)
+SELECT gen_random_uuid(), s.id, 'general', true, now()
+FROM spaces s
+WHERE NOT EXISTS (SELECT 1 FROM channels c WHERE c.spaceid = s.id AND c.isdefault);
+
+ALTER TABLE messages ALTER COLUMN userid DROP NOT NULL;
+DO
+$$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_schema = current_schema() AND table_name = 'messages' AND column_name = 'channelid') THEN
+        ALTER TABLE messages ADD COLUMN channelid uuid references channels (id);
+        UPDATE messages m SET channelid = c.id
+        FROM channels c
+        WHERE c.spaceid = m.spaceid AND c.isdefault;
+        ALTER TABLE messages ALTER COLUMN channelid SET NOT NULL;
+    END IF;
+END
+$$;
+
+ALTER TABLE invites ADD COLUMN IF NOT EXISTS invitedby uuid references users (id);
+ALTER TABLE invites ADD COLUMN IF NOT EXISTS createdat timestamptz not null default now();
+DO
+$$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_schema = current_schema() AND table_name = 'invites' AND column_name = 'userid') THEN
+        ALTER TABLE invites ADD COLUMN userid uuid references users (id);
+        UPDATE invites i SET userid = u.id
+        FROM users u
+        WHERE u.email = i.email AND u.emailverified;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_schema = current_schema() AND table_name = 'invites' AND column_name = 'expiresat') THEN
+        -- pending invites get the default lifetime from now
+        ALTER TABLE invites ADD COLUMN expiresat timestamptz not null default now() + interval '7 days';
+        ALTER TABLE invites ALTER COLUMN expiresat DROP DEFAULT;
+    END IF;
+END
+$$;
@@ -1,15 +1,25 @@
 package eligos
 
 import (
+	"errors"
 	"github.com/google/uuid"
+	"strings"
 	"time"
 )
 
+// ErrLastOwner is returned when a change would leave a space without an owner
+var ErrLastOwner = errors.New("the last owner of a space can't leave it")
+
 type User struct {
-	Id       uuid.UUID `json:"id"`
-	Name     string    `json:"name"`
-	Email    string    `json:"email"`
-	Password string    `json:"-"`
+	Id            uuid.UUID `json:"id"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	Password      string    `json:"-"`
+	EmailVerified bool      `json:"emailVerified"`
+	TotpSecret    string    `json:"-"`
+	TotpEnabled   bool      `json:"totpEnabled"`
+	// hashes of unused two-factor recovery codes
+	RecoveryCodes []string `json:"-"`
 }
 
 type UserServiceI interface {
@@ -17,32 +27,353 @@ type UserServiceI interface {
 	GetUser(email string) (*User, error)
 	GetUserById(id uuid.UUID) (*User, error)
 	GetSpaces(userid uuid.UUID) (*[]Space, error)
+	SetEmailVerified(userid uuid.UUID) error
+	UpdatePassword(userid uuid.UUID, password string) error
+	SetTotp(userid uuid.UUID, secret string, enabled bool, recoveryCodes []string) error
+	// UseTotpStep records the time step of an accepted code. It returns false if that step
+	// or a later one was already used, so a code can't be replayed.
+	UseTotpStep(userid uuid.UUID, step int64) (bool, error)
+	// UseRecoveryCode removes a recovery code hash from the user. It returns false if the code wasn't found.
+	UseRecoveryCode(userid uuid.UUID, hash string) (bool, error)
+	UpdateName(userid uuid.UUID, name string) error
+	// UpdateEmail changes the email of a user to an address that has been verified
+	UpdateEmail(userid uuid.UUID, email string) error
+	// DeleteUser deletes a user and everything that belongs to them. Their
+	// messages are kept but no longer linked to them. It returns ErrLastOwner if they are
+	// the only owner of a space.
+	DeleteUser(userid uuid.UUID) error
 }
 
 type Space struct {
 	Id   uuid.UUID `json:"id"`
 	Name string    `json:"name"`
+	// SpaceKindSpace or SpaceKindDm
+	Kind        string `json:"kind"`
+	Visibility  string `json:"visibility"`
+	Description string `json:"description"`
+	Topic       string `json:"topic"`
+	// url of the space's icon, empty for none
+	Icon      string    `json:"icon"`
+	CreatedAt time.Time `json:"createdAt"`
+	// nil if the creator deleted their account
+	CreatedBy *uuid.UUID `json:"createdBy"`
+	// archived spaces are read-only and hidden from lists, nil if active
+	ArchivedAt *time.Time `json:"archivedAt"`
+}
+
+// SpaceListing is a space in the public directory
+type SpaceListing struct {
+	Space
+	MemberCount int `json:"memberCount"`
+}
+
+const (
+	SpaceKindSpace = "space"
+	// a direct conversation, its name is made of the names of the other participants
+	SpaceKindDm = "dm"
+)
+
+// Who can find and join a space
+const (
+	// members are added by invite only
+	VisibilityPrivate = "private"
+	// listed in the directory, anyone can join
+	VisibilityPublic = "public"
+	// anyone with the id can join, but it isn't listed
+	VisibilityUnlisted = "unlisted"
+)
+
+// IsVisibility reports whether v is one of the visibility settings
+func IsVisibility(v string) bool {
+	return v == VisibilityPrivate || v == VisibilityPublic || v == VisibilityUnlisted
+}
+
+// Member is a user in a space along with their role in it
+type Member struct {
+	UserId uuid.UUID `json:"userid"`
+	Name   string    `json:"name"`
+	Role   string    `json:"role"`
+	// time until which the member can't post, nil if they aren't muted
+	MutedUntil *time.Time `json:"mutedUntil"`
+}
+
+// Can reports whether the member's role grants a permission, taking an active mute into account
+func (m *Member) Can(permission string) bool {
+	if permission == PermissionPostMessages && m.Muted() {
+		return false
+	}
+	return RoleCan(m.Role, permission)
+}
+
+// Muted reports whether the member is currently muted
+func (m *Member) Muted() bool {
+	return m.MutedUntil != nil && m.MutedUntil.After(time.Now())
 }
 
 type SpaceServiceI interface {
+	// CreateSpace creates a space with userid as its owner and a default channel
 	CreateSpace(space *Space, userid uuid.UUID) error
-	AddUserById(userid, spaceid uuid.UUID) error
+	// GetOrCreateDm returns the direct conversation between exactly the given users, creating
+	// it if there is none yet. The bool is true if it was created. Participants who left an
+	// existing conversation are added back.
+	GetOrCreateDm(userids []uuid.UUID) (*Space, bool, error)
+	GetSpace(id uuid.UUID) (*Space, error)
+	SetVisibility(spaceid uuid.UUID, visibility string) error
+	// UpdateSpace saves the name, description, topic and icon of a space
+	UpdateSpace(space *Space) error
+	SetArchived(spaceid uuid.UUID, archived bool) error
+	// GetPublicSpaces returns public spaces whose name contains query, largest first
+	GetPublicSpaces(query string, limit, offset int) ([]SpaceListing, error)
+	AddUserById(userid, spaceid uuid.UUID, role string) error
 	RemoveUserById(userid, spaceid uuid.UUID) error
 	GetUsersInSpace(spaceid uuid.UUID) (*[]User, error)
+	GetMembers(spaceid uuid.UUID) ([]Member, error)
+	// GetMember returns the membership of a user in a space, or an error if they aren't a member
+	GetMember(userid, spaceid uuid.UUID) (*Member, error)
+	// GetRole returns the role of a user in a space, or an error if they aren't a member
+	GetRole(userid, spaceid uuid.UUID) (string, error)
+	SetRole(userid, spaceid uuid.UUID, role string) error
+	// LeaveSpace removes a member from a space. It returns ErrLastOwner instead if they are its only owner.
+	LeaveSpace(userid, spaceid uuid.UUID) error
+	// TransferOwnership makes to an owner of the space and from an admin
+	TransferOwnership(spaceid, from, to uuid.UUID) error
+	// GetSoleOwnedSpaces returns the spaces that userid is the only owner of
+	GetSoleOwnedSpaces(userid uuid.UUID) ([]Space, error)
+	// SetMute keeps a member from posting until the given time, nil lifts the mute
+	SetMute(userid, spaceid uuid.UUID, until *time.Time) error
+	// DeleteSpaceById deletes a space with its channels, messages, members, bans and invites
 	DeleteSpaceById(spaceid uuid.UUID) error
 }
 
+// Roles a user can have in a space, from most to least privileged
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleGuest  = "guest"
+)
+
+// Actions in a space that depend on the role of the user
+const (
+	PermissionReadMessages      = "messages.read"
+	PermissionPostMessages      = "messages.post"
+	PermissionAddMembers        = "members.add"
+	PermissionManageRoles       = "members.roles"
+	PermissionModerate          = "members.moderate"
+	PermissionDeleteSpace       = "space.delete"
+	PermissionArchiveSpace      = "space.archive"
+	PermissionTransferOwnership = "space.transfer"
+	PermissionViewAudit         = "audit.view"
+	PermissionManageChannels    = "channels.manage"
+	PermissionManageSpace       = "space.manage"
+)
+
+var rolePermissions = map[string][]string{
+	RoleOwner:  {PermissionReadMessages, PermissionPostMessages, PermissionAddMembers, PermissionManageRoles, PermissionModerate, PermissionManageChannels, PermissionManageSpace, PermissionArchiveSpace, PermissionTransferOwnership, PermissionDeleteSpace, PermissionViewAudit},
+	RoleAdmin:  {PermissionReadMessages, PermissionPostMessages, PermissionAddMembers, PermissionManageRoles, PermissionModerate, PermissionManageChannels, PermissionManageSpace},
+	RoleMember: {PermissionReadMessages, PermissionPostMessages},
+	RoleGuest:  {PermissionReadMessages},
+}
+
+var roleRanks = map[string]int{RoleGuest: 1, RoleMember: 2, RoleAdmin: 3, RoleOwner: 4}
+
+// IsRole reports whether role is one of the known roles
+func IsRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// RoleCan reports whether a role grants a permission
+func RoleCan(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleOutranks reports whether role a is more privileged than role b
+func RoleOutranks(a, b string) bool {
+	return roleRanks[a] > roleRanks[b]
+}
+
+// Channel is a topic inside a space with its own stream of messages. Members of the
+// space can use it as their role allows, unless the channel overrides that for their role.
+type Channel struct {
+	Id      uuid.UUID `json:"id"`
+	SpaceId uuid.UUID `json:"spaceid"`
+	Name    string    `json:"name"`
+	Topic   string    `json:"topic"`
+	// the channel a space is created with, it can't be deleted
+	IsDefault bool `json:"isDefault"`
+	// permissions granted or denied by role, e.g. {"guest": {"messages.post": true}}
+	Overrides map[string]map[string]bool `json:"overrides"`
+	CreatedAt time.Time                  `json:"createdAt"`
+}
+
+// DefaultChannelName is the name of the channel every space starts with
+const DefaultChannelName = "general"
+
+// ChannelPermissions lists the permissions a channel can override
+var ChannelPermissions = []string{PermissionReadMessages, PermissionPostMessages}
+
+// MemberCan reports whether a member of the channel's space has a permission in the channel.
+// Owners can't be overridden, so a channel can't be locked away from them.
+func (c *Channel) MemberCan(m *Member, permission string) bool {
+	allow, ok := c.Overrides[m.Role][permission]
+	if !ok || m.Role == RoleOwner {
+		return m.Can(permission)
+	}
+	if permission == PermissionPostMessages && m.Muted() {
+		return false
+	}
+	return allow
+}
+
+type ChannelServiceI interface {
+	CreateChannel(channel *Channel) error
+	GetChannel(id uuid.UUID) (*Channel, error)
+	GetChannels(spaceid uuid.UUID) ([]Channel, error)
+	GetDefaultChannel(spaceid uuid.UUID) (*Channel, error)
+	// UpdateChannel saves the name, topic and overrides of a channel
+	UpdateChannel(channel *Channel) error
+	// DeleteChannel deletes a channel along with its messages
+	DeleteChannel(id uuid.UUID) error
+}
+
+// InviteLink lets anyone with its token join a space, until it expires or is used up
+type InviteLink struct {
+	Id      uuid.UUID `json:"id"`
+	SpaceId uuid.UUID `json:"spaceid"`
+	Hash    string    `json:"-"`
+	// role given to users who join with the link
+	Role string `json:"role"`
+	// nil for unlimited uses
+	MaxUses *int `json:"maxUses"`
+	Uses    int  `json:"uses"`
+	// nil if the link never expires
+	ExpiresAt *time.Time `json:"expiresAt"`
+	// nil if the creator deleted their account
+	CreatedBy *uuid.UUID `json:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// Usable reports whether the link hasn't expired or been used up
+func (l *InviteLink) Usable() bool {
+	if l.ExpiresAt != nil && !l.ExpiresAt.After(time.Now()) {
+		return false
+	}
+	return l.MaxUses == nil || l.Uses < *l.MaxUses
+}
+
+type InviteLinkServiceI interface {
+	CreateInviteLink(link *InviteLink) error
+	GetInviteLinkByHash(hash string) (*InviteLink, error)
+	GetInviteLinks(spaceid uuid.UUID) ([]InviteLink, error)
+	DeleteInviteLink(id, spaceid uuid.UUID) error
+	// RedeemInviteLink counts a use of the link and adds userid to its space with the link's
+	// role. It fails if the link expired or was used up in the meantime.
+	RedeemInviteLink(id, userid uuid.UUID) error
+}
+
+// Ban keeps a user out of a space until it is lifted
+type Ban struct {
+	SpaceId uuid.UUID `json:"spaceid"`
+	UserId  uuid.UUID `json:"userid"`
+	// name of the banned user, filled in by GetBans
+	Name string `json:"name"`
+	// nil if the moderator deleted their account
+	BannedBy  *uuid.UUID `json:"bannedBy"`
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type BanServiceI interface {
+	// BanUser removes a user from a space and keeps them from being added to it again
+	BanUser(ban *Ban) error
+	UnbanUser(userid, spaceid uuid.UUID) error
+	IsBanned(userid, spaceid uuid.UUID) (bool, error)
+	GetBans(spaceid uuid.UUID) ([]Ban, error)
+}
+
+// AuditEntry records an administrative action in a space. Entries are never changed or deleted.
+type AuditEntry struct {
+	Id      int64     `json:"id"`
+	SpaceId uuid.UUID `json:"spaceid"`
+	// user who performed the action
+	ActorId uuid.UUID `json:"actorid"`
+	// user the action was performed on, nil for actions on the space itself
+	TargetId  *uuid.UUID     `json:"targetid"`
+	Action    string         `json:"action"`
+	Metadata  map[string]any `json:"metadata"`
+	CreatedAt time.Time      `json:"createdAt"`
+}
+
+// Actions recorded in the audit log
+const (
+	AuditSpaceCreated         = "space.created"
+	AuditSpaceDeleted         = "space.deleted"
+	AuditSpaceArchived        = "space.archived"
+	AuditSpaceUnarchived      = "space.unarchived"
+	AuditVisibilityChanged    = "space.visibility_changed"
+	AuditSpaceUpdated         = "space.updated"
+	AuditMemberAdded          = "member.added"
+	AuditMemberJoined         = "member.joined"
+	AuditMemberLeft           = "member.left"
+	AuditOwnershipTransferred = "space.ownership_transferred"
+	AuditRoleChanged          = "member.role_changed"
+	AuditMemberKicked         = "member.kicked"
+	AuditMemberBanned         = "member.banned"
+	AuditMemberUnbanned       = "member.unbanned"
+	AuditMemberMuted          = "member.muted"
+	AuditMemberUnmuted        = "member.unmuted"
+	AuditInviteCreated        = "invite.created"
+	AuditInviteAccepted       = "invite.accepted"
+	AuditInviteRejected       = "invite.rejected"
+	AuditInviteRevoked        = "invite.revoked"
+	AuditInviteLinkCreated    = "invite_link.created"
+	AuditInviteLinkRevoked    = "invite_link.revoked"
+	AuditInviteLinkRedeemed   = "invite_link.redeemed"
+	AuditChannelCreated       = "channel.created"
+	AuditChannelUpdated       = "channel.updated"
+	AuditChannelDeleted       = "channel.deleted"
+)
+
+// AuditFilter selects audit entries of a space. Zero fields don't filter.
+type AuditFilter struct {
+	SpaceId  uuid.UUID
+	ActorId  *uuid.UUID
+	TargetId *uuid.UUID
+	Action   string
+	Since    time.Time
+	Until    time.Time
+	// only entries with a lower id, to page backwards through the log
+	Before int64
+	Limit  int
+}
+
+type AuditServiceI interface {
+	CreateAuditEntry(entry *AuditEntry) error
+	// GetAuditEntries returns matching entries, newest first
+	GetAuditEntries(filter AuditFilter) ([]AuditEntry, error)
+}
+
 type Message struct {
 	Id        uuid.UUID `json:"id"`
 	UserId    uuid.UUID `json:"userid"`
 	SpaceId   uuid.UUID `json:"spaceid"`
+	ChannelId uuid.UUID `json:"channelid"`
 	Body      string    `json:"body"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
 type MessageServiceI interface {
-	CreateMessage(m MessageWUser) (MessageWUser, error)
-	GetMessages(spaceid uuid.UUID) (*[]MessageWUser, error)
+	// CreateMessage stores a message by userid, stamped with the current time, and returns it with its author
+	CreateMessage(userid, channelid uuid.UUID, body string) (MessageWUser, error)
+	GetMessages(channelid uuid.UUID) (*[]MessageWUser, error)
+	// GetMessagesByUser returns every message a user authored, oldest first
+	GetMessagesByUser(userid uuid.UUID) ([]Message, error)
 }
 
 type MessageWUser struct {
@@ -55,10 +386,187 @@ type Invite struct {
 	SpaceId   uuid.UUID `json:"spaceid"`
 	SpaceName string    `json:"spaceName"`
 	Email     string    `json:"email"`
+	// UserId is set once the account with the invited address has verified it.
+	// Invites to addresses nobody has registered yet wait with no user.
+	// It is never sent to clients, as it would tell inviters which addresses have an account.
+	UserId *uuid.UUID `json:"-"`
+	// InvitedBy is null once the inviter deletes their account
+	InvitedBy *uuid.UUID `json:"invitedBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+}
+
+func (i *Invite) Expired() bool {
+	return !i.ExpiresAt.After(time.Now())
 }
 
 type InviteServiceI interface {
+	// CreateInvite fails if the address already has a pending invite to the space,
+	// an expired one is replaced
 	CreateInvite(invite *Invite) error
 	DeleteInviteById(id uuid.UUID) error
-	GetInvitesByUser(email string) ([]Invite, error)
+	GetInviteById(id uuid.UUID) (*Invite, error)
+	// GetInvitesByUser returns the unexpired invites attached to a user
+	GetInvitesByUser(userid uuid.UUID) ([]Invite, error)
+	// GetInvitesBySpace returns the unexpired invites sent for a space
+	GetInvitesBySpace(spaceid uuid.UUID) ([]Invite, error)
+	// GetInvitesSentBy returns every invite a user has sent, including expired ones
+	GetInvitesSentBy(userid uuid.UUID) ([]Invite, error)
+	// AcceptInvite deletes an unexpired invite and makes the user it is attached to a
+	// member of its space with role
+	AcceptInvite(id uuid.UUID, role string) error
+	// AttachInvites gives the pending invites sent to email to userid, and returns them
+	AttachInvites(email string, userid uuid.UUID) ([]Invite, error)
+}
+
+type Session struct {
+	Id          uuid.UUID `json:"id"`
+	UserId      uuid.UUID `json:"userid"`
+	RefreshHash string    `json:"-"`
+	// hashes of the last refresh tokens rotated away, newest first. Seeing one again means it was stolen.
+	PreviousHashes []string  `json:"-"`
+	CreatedAt      time.Time `json:"createdAt"`
+	LastUsedAt     time.Time `json:"lastUsedAt"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	Revoked        bool      `json:"revoked"`
+	DeviceLabel    string    `json:"deviceLabel"`
+	Ip             string    `json:"ip"`
+	UserAgent      string    `json:"userAgent"`
+}
+
+type SessionServiceI interface {
+	CreateSession(session *Session) error
+	GetSession(id uuid.UUID) (*Session, error)
+	// RotateSession replaces the refresh token hash of an active session if it is still oldHash.
+	// It returns false if the token was already rotated or the session revoked.
+	RotateSession(id uuid.UUID, oldHash, newHash string, expiresAt time.Time) (bool, error)
+	RevokeSession(id uuid.UUID) error
+	TouchSession(id uuid.UUID) error
+	GetSessionsByUser(userid uuid.UUID) ([]Session, error)
+	RevokeOtherSessions(userid, keep uuid.UUID) ([]uuid.UUID, error)
+	RevokeAllSessions(userid uuid.UUID) ([]uuid.UUID, error)
+}
+
+// UserToken is a single use token sent to a user's email address.
+// Only the hash of the token is stored.
+type UserToken struct {
+	Hash      string    `json:"-"`
+	UserId    uuid.UUID `json:"userid"`
+	Purpose   string    `json:"purpose"`
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+	TokenPurposeLoginTotp     = "login_totp"
+	TokenPurposeChangeEmail   = "change_email"
+)
+
+type UserTokenServiceI interface {
+	CreateToken(token *UserToken) error
+	// ConsumeToken deletes an unexpired token and returns it. A token can only be consumed once.
+	ConsumeToken(hash, purpose string) (*UserToken, error)
+	DeleteTokens(userid uuid.UUID, purpose string) error
+}
+
+// Mailer sends plain text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// OidcState is a pending single sign-on login waiting for the identity provider to redirect back
+type OidcState struct {
+	StateHash    string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+type OidcServiceI interface {
+	CreateState(state *OidcState) error
+	// ConsumeState deletes an unexpired login state and returns it
+	ConsumeState(stateHash string) (*OidcState, error)
+	// GetUserByIdentity returns the user linked to an account at an identity provider
+	GetUserByIdentity(issuer, subject string) (*User, error)
+	LinkIdentity(userid uuid.UUID, issuer, subject string) error
+	// ClaimUser links an identity to an account whose email was never verified. Whoever
+	// registered the account may not own the address, so its password, two-factor
+	// authentication, sessions and tokens are all cleared. It returns the revoked session ids.
+	ClaimUser(userid uuid.UUID, issuer, subject string) ([]uuid.UUID, error)
+}
+
+// ApiToken is a named, long lived credential for scripts and bots.
+// It grants a subset of the user's access, limited by its scopes.
+type ApiToken struct {
+	Id         uuid.UUID  `json:"id"`
+	UserId     uuid.UUID  `json:"userid"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	// nil if the token never expires
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+const (
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+	ScopeSpacesManage  = "spaces:manage"
+)
+
+// Scopes lists every scope an api token can be given
+var Scopes = []string{ScopeMessagesRead, ScopeMessagesWrite, ScopeSpacesManage}
+
+type ApiTokenServiceI interface {
+	CreateApiToken(token *ApiToken) error
+	GetApiTokenByHash(hash string) (*ApiToken, error)
+	GetApiTokensByUser(userid uuid.UUID) ([]ApiToken, error)
+	DeleteApiToken(id, userid uuid.UUID) error
+	// DeleteApiTokensByUser deletes every token of a user and returns their ids
+	DeleteApiTokensByUser(userid uuid.UUID) ([]uuid.UUID, error)
+	TouchApiToken(id uuid.UUID) error
+}
+
+// LoginAttemptServiceI tracks failed attempts by key, e.g. an account or an ip address
+type LoginAttemptServiceI interface {
+	// GetLockout returns the time until which key is locked out, zero if it isn't
+	GetLockout(key string) (time.Time, error)
+	// RecordFailure counts a failed attempt and returns the number of failures
+	// for key. Failures older than window are forgotten.
+	RecordFailure(key string, window time.Duration) (int, error)
+	SetLockout(key string, until time.Time) error
+	ResetAttempts(key string) error
+}
+
+const (
+	AttemptLogin    = "login"
+	AttemptRegister = "register"
+	AttemptInvite   = "invite"
+	// requests for a password reset email
+	AttemptResetPassword = "resetpassword"
+	// requests for another verification email
+	AttemptVerifyEmail = "verifyemail"
+)
+
+// AccountAttemptKey is the key failed logins to an account are tracked under
+func AccountAttemptKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+// UserAttemptKey is the key attempts of an action by a user are tracked under
+func UserAttemptKey(action string, userid uuid.UUID) string {
+	return action + ":user:" + userid.String()
+}
+
+// AddressAttemptKey is the key attempts of an action for an email address are tracked under
+func AddressAttemptKey(action, email string) string {
+	return action + ":address:" + strings.ToLower(email)
+}
+
+// IpAttemptKey is the key attempts of an action from an ip address are tracked under
+func IpAttemptKey(action, ip string) string {
+	return action + ":ip:" + ip
 }